@@ -18,12 +18,18 @@
 package config
 
 import (
+	"cmp"
 	"errors"
+	"fmt"
 	"log"
+	"maps"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/marshyski/pal/data"
@@ -34,7 +40,11 @@ import (
 
 const (
 	defaultNotifications       = 100
+	defaultRuns                = 1000
+	defaultChainDepth          = 10
+	defaultLeaseTTL            = 15 // seconds
 	MB                   int64 = 1000 * 1000
+	workflowsKey               = "workflows"
 )
 
 var (
@@ -46,13 +56,19 @@ func validateSafeString(fl validator.FieldLevel) bool {
 	return safeStringRegex.MatchString(fl.Field().String())
 }
 
-func validateDefs(res map[string][]data.ActionData) bool {
+func newValidator() *validator.Validate {
 	validate := validator.New(validator.WithRequiredStructEnabled())
 	err := validate.RegisterValidation("safestring", validateSafeString)
 	if err != nil {
 		log.Println(err)
 	}
 
+	return validate
+}
+
+func validateDefs(res map[string][]data.ActionData) bool {
+	validate := newValidator()
+
 	for _, v := range res {
 		for _, e := range v {
 			err := validate.Struct(e)
@@ -60,12 +76,208 @@ func validateDefs(res map[string][]data.ActionData) bool {
 				log.Println(err)
 				return false
 			}
+			if err := validateParams(e.Params); err != nil {
+				log.Println("error action " + e.Action + " " + err.Error())
+				return false
+			}
+			if err := validateOutputs(e); err != nil {
+				log.Println("error action " + e.Action + " " + err.Error())
+				return false
+			}
+			if err := checkWindows(GetConfigCalendars(), e.AllowedWindows, e.BlockedWindows); err != nil {
+				log.Println("error action " + e.Action + " " + err.Error())
+				return false
+			}
 		}
 	}
 
 	return true
 }
 
+// validateOutputs checks the output regexes of retry_on and run trigger when conditions compile, and that
+// output_format json has an output to parse
+func validateOutputs(action data.ActionData) error {
+	if action.OutputFormat == "json" && !action.Output {
+		return errors.New("output_format json requires output: true")
+	}
+
+	for _, pattern := range action.OnError.RetryOn.Output {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid retry_on output %s", err.Error())
+		}
+	}
+
+	for _, run := range slices.Concat(action.OnSuccess.Run, action.OnError.Run) {
+		if _, err := regexp.Compile(run.When.Output); err != nil {
+			return fmt.Errorf("invalid when output of run %s/%s %s", run.Group, run.Action, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// validateParams checks param env names are unique and their defaults, enums and regexes compile into a JSON Schema
+func validateParams(params []data.Param) error {
+	names := make(map[string]string)
+	for _, p := range params {
+		env := utils.ParamEnv(p.Name)
+		if name, ok := names[env]; ok {
+			return fmt.Errorf("duplicate param %s and %s are both %s", name, p.Name, env)
+		}
+		names[env] = p.Name
+	}
+
+	if len(params) == 0 {
+		return nil
+	}
+
+	_, err := utils.CompileParams(params)
+
+	return err
+}
+
+// validateWorkflow checks the steps of a workflow reference existing actions and their needs form a DAG,
+// a workflow needs auth_header when a step action has auth
+func validateWorkflow(validate *validator.Validate, workflow data.Workflow, groups map[string][]data.ActionData) error {
+	err := validate.Struct(workflow)
+	if err != nil {
+		return err
+	}
+
+	needs := make(map[string][]string)
+	for _, step := range workflow.Steps {
+		if _, ok := needs[step.Name]; ok {
+			return fmt.Errorf("error duplicate step %s", step.Name)
+		}
+		needs[step.Name] = step.Needs
+
+		i := slices.IndexFunc(groups[step.Group], func(a data.ActionData) bool { return a.Action == step.Action })
+		if i < 0 {
+			return fmt.Errorf("error step %s action %s/%s not found", step.Name, step.Group, step.Action)
+		}
+
+		// Steps run without the auth of their action, a workflow without auth would let anyone run it
+		action := groups[step.Group][i]
+		if workflow.AuthHeader == "" && (action.AuthHeader != "" || action.Auth.Type != "") {
+			return fmt.Errorf("error step %s action %s/%s requires auth, set auth_header on the workflow", step.Name, step.Group, step.Action)
+		}
+	}
+
+	for _, step := range workflow.Steps {
+		for _, need := range step.Needs {
+			if _, ok := needs[need]; !ok || need == step.Name {
+				return fmt.Errorf("error step %s needs unknown step %s", step.Name, need)
+			}
+		}
+	}
+
+	// Remove steps whose needs are all removed, any steps left over are part of a cycle
+	for len(needs) > 0 {
+		removed := false
+		for name, stepNeeds := range needs {
+			if !slices.ContainsFunc(stepNeeds, func(need string) bool { _, ok := needs[need]; return ok }) {
+				delete(needs, name)
+				removed = true
+			}
+		}
+		if !removed {
+			return errors.New("error workflow steps needs has a cycle")
+		}
+	}
+
+	return nil
+}
+
+// ValidateTriggers builds the graph of on_success/on_error run triggers between actions and returns an error
+// for the first cycle found, cycles with a trigger marked allow_cycle are logged instead
+func ValidateTriggers(groups map[string][]data.ActionData) error {
+	edges := make(map[string][]data.Run)
+	for group, actions := range groups {
+		for _, e := range actions {
+			edges[group+"/"+e.Action] = slices.Concat(e.OnSuccess.Run, e.OnError.Run)
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+	var allowed []bool
+
+	var visit func(node string) error
+	visit = func(node string) error {
+		state[node] = visiting
+		path = append(path, node)
+		for _, e := range edges[node] {
+			next := e.Group + "/" + e.Action
+			if _, ok := edges[next]; !ok {
+				continue
+			}
+			allowed = append(allowed, e.AllowCycle)
+			switch state[next] {
+			case visiting:
+				start := slices.Index(path, next)
+				cycle := strings.Join(append(slices.Clone(path[start:]), next), " -> ")
+				if !slices.Contains(allowed[start:], true) {
+					return fmt.Errorf("error trigger cycle %s, set allow_cycle: true on a run of the cycle if it's intentional", cycle)
+				}
+				log.Println("warning allowed trigger cycle " + cycle)
+			case unvisited:
+				if err := visit(next); err != nil {
+					return err
+				}
+			}
+			allowed = allowed[:len(allowed)-1]
+		}
+		path = path[:len(path)-1]
+		state[node] = visited
+		return nil
+	}
+
+	nodes := slices.Sorted(maps.Keys(edges))
+	for _, node := range nodes {
+		if state[node] == unvisited {
+			if err := visit(node); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// readDefs reads the top level keys of an actions file, the workflows key is reserved for workflow definitions
+func readDefs(fileLoc string) (map[string]yaml.Node, error) {
+	defs, err := os.ReadFile(fileLoc)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes map[string]yaml.Node
+	err = yaml.Unmarshal(defs, &nodes)
+	if err != nil {
+		return nil, err
+	}
+
+	return nodes, nil
+}
+
+// actionGroup checks if a top level key holds actions instead of workflow definitions
+func actionGroup(node yaml.Node) bool {
+	var items []map[string]any
+	if err := node.Decode(&items); err != nil {
+		return false
+	}
+
+	return slices.ContainsFunc(items, func(item map[string]any) bool {
+		_, ok := item["action"]
+		return ok
+	})
+}
+
 func ReadConfig(dir string) map[string][]data.ActionData {
 	files, err := os.ReadDir(dir)
 	if err != nil {
@@ -77,14 +289,30 @@ func ReadConfig(dir string) map[string][]data.ActionData {
 	for _, file := range files {
 		if filepath.Ext(file.Name()) == ".yml" {
 			fileLoc := filepath.Clean(dir + "/" + file.Name())
-			defs, err := os.ReadFile(fileLoc)
+			nodes, err := readDefs(fileLoc)
 			if err != nil {
 				log.Println("error reading file: "+fileLoc, err)
 				continue // Skip to the next file
 			}
 
-			var groupData map[string][]data.ActionData
-			err = yaml.Unmarshal(defs, &groupData)
+			// An action group named workflows would be read as workflow definitions and silently dropped
+			if node, ok := nodes[workflowsKey]; ok && actionGroup(node) {
+				log.Println("error group name " + workflowsKey + " is reserved for workflow definitions: " + fileLoc)
+				continue
+			}
+
+			groupData := make(map[string][]data.ActionData)
+			for k, node := range nodes {
+				if k == workflowsKey {
+					continue
+				}
+				var actions []data.ActionData
+				err = node.Decode(&actions)
+				if err != nil {
+					break
+				}
+				groupData[k] = actions
+			}
 			if err != nil {
 				log.Println("error unmarshaling YAML: "+fileLoc, err)
 				continue
@@ -100,6 +328,49 @@ func ReadConfig(dir string) map[string][]data.ActionData {
 	return groups
 }
 
+// ReadWorkflows reads the workflows key of the actions files, invalid workflows are logged and skipped
+func ReadWorkflows(dir string, groups map[string][]data.ActionData) map[string]data.Workflow {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		log.Fatalln("error reading directory: "+dir, err)
+	}
+
+	validate := newValidator()
+	workflows := make(map[string]data.Workflow)
+
+	for _, file := range files {
+		if filepath.Ext(file.Name()) == ".yml" {
+			fileLoc := filepath.Clean(dir + "/" + file.Name())
+			nodes, err := readDefs(fileLoc)
+			if err != nil {
+				continue // Logged by ReadConfig
+			}
+
+			node, ok := nodes[workflowsKey]
+			if !ok || actionGroup(node) {
+				continue // Reserved group name is logged by ReadConfig
+			}
+
+			var fileWorkflows []data.Workflow
+			err = node.Decode(&fileWorkflows)
+			if err != nil {
+				log.Println("error unmarshaling YAML: "+fileLoc, err)
+				continue
+			}
+
+			for _, e := range fileWorkflows {
+				err = validateWorkflow(validate, e, groups)
+				if err != nil {
+					log.Println("error invalid workflow: "+e.Name, err)
+					continue
+				}
+				workflows[e.Name] = e
+			}
+		}
+	}
+	return workflows
+}
+
 func InitConfig(location string) error {
 	if !utils.FileExists(location) {
 		return errors.New("error file does not exist: " + location)
@@ -171,6 +442,17 @@ func InitConfig(location string) error {
 
 	configMap.Set("global_working_dir", workingDir)
 	configMap.Set("global_debug", config.Global.Debug)
+	configMap.Set("global_max_workers", config.Global.MaxWorkers)
+	// Set default value for global.max_chain_depth to defaultChainDepth const
+	if config.Global.MaxDepth == 0 {
+		configMap.Set("global_max_chain_depth", defaultChainDepth)
+	} else {
+		configMap.Set("global_max_chain_depth", config.Global.MaxDepth)
+	}
+	configMap.Set("global_env", config.Global.Env)
+	configMap.Set("global_env_file", config.Global.EnvFile)
+	configMap.Set("global_templates", config.Global.Templates)
+	configMap.Set("groups", config.Groups)
 	configMap.Set("global_container_cmd", containerCmd)
 	configMap.Set("http_prometheus", config.HTTP.Prometheus)
 	configMap.Set("http_ipv6", config.HTTP.IPV6)
@@ -196,6 +478,26 @@ func InitConfig(location string) error {
 	} else {
 		configMap.Set("notifications_store_max", config.Notifications.StoreMax)
 	}
+	// Set default value for runs.store_max to defaultRuns const
+	if config.Runs.StoreMax == 0 {
+		configMap.Set("runs_store_max", defaultRuns)
+	} else {
+		configMap.Set("runs_store_max", config.Runs.StoreMax)
+	}
+	configMap.Set("runs_retention_days", config.Runs.RetentionDays)
+	configMap.Set("leader_type", config.Leader.Type)
+	configMap.Set("leader_lease_file", config.Leader.LeaseFile)
+	// Set default node name to the hostname
+	if config.Leader.Node == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "pal"
+		}
+		configMap.Set("leader_node", hostname)
+	} else {
+		configMap.Set("leader_node", config.Leader.Node)
+	}
+	configMap.Set("leader_ttl", cmp.Or(config.Leader.TTL, defaultLeaseTTL))
 	// Set default value for global.cmdprefix to sh
 	if config.Global.CmdPrefix == "" {
 		configMap.Set("global_cmd_prefix", "/bin/sh -c")
@@ -209,6 +511,50 @@ func InitConfig(location string) error {
 		configMap.Set("global_timezone", config.Global.Timezone)
 	}
 
+	calendars, err := loadCalendars(config)
+	if err != nil {
+		log.Println(err)
+		log.Fatalln("error panic " + location + " is invalid")
+	}
+	configMap.Set("calendars", calendars)
+
+	return nil
+}
+
+// loadCalendars reads the iCal holidays of the calendars and checks the windows of groups reference them
+func loadCalendars(config *data.Config) (map[string]data.Calendar, error) {
+	loc, err := time.LoadLocation(cmp.Or(config.Global.Timezone, "UTC"))
+	if err != nil {
+		return nil, err
+	}
+
+	calendars := make(map[string]data.Calendar)
+	for name, cal := range config.Calendars {
+		if err := utils.LoadCalendar(&cal, loc); err != nil {
+			return nil, fmt.Errorf("error calendar %s: %w", name, err)
+		}
+		calendars[name] = cal
+	}
+
+	for group, groupConfig := range config.Groups {
+		if err := checkWindows(calendars, groupConfig.AllowedWindows, groupConfig.BlockedWindows); err != nil {
+			return nil, fmt.Errorf("error group %s: %w", group, err)
+		}
+	}
+
+	return calendars, nil
+}
+
+// checkWindows checks allowed and blocked windows are names of calendars
+func checkWindows(calendars map[string]data.Calendar, windows ...[]string) error {
+	for _, names := range windows {
+		for _, name := range names {
+			if _, ok := calendars[name]; !ok {
+				return fmt.Errorf("calendar %s not found", name)
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -275,6 +621,42 @@ func GetConfigWebHooks() []data.Webhook {
 	return v
 }
 
+func GetConfigEnv() map[string]string {
+	val, _ := configMap.Get("global_env")
+	v, ok := val.(map[string]string)
+	if !ok {
+		return map[string]string{}
+	}
+	return v
+}
+
+func GetConfigGroup(group string) data.GroupConfig {
+	val, _ := configMap.Get("groups")
+	v, ok := val.(map[string]data.GroupConfig)
+	if !ok {
+		return data.GroupConfig{}
+	}
+	return v[group]
+}
+
+func GetConfigCalendars() map[string]data.Calendar {
+	val, _ := configMap.Get("calendars")
+	v, ok := val.(map[string]data.Calendar)
+	if !ok {
+		return map[string]data.Calendar{}
+	}
+	return v
+}
+
+func GetConfigWorkflows() map[string]data.Workflow {
+	val, _ := configMap.Get("workflows")
+	v, ok := val.(map[string]data.Workflow)
+	if !ok {
+		return map[string]data.Workflow{}
+	}
+	return v
+}
+
 func GetConfigUsers() []data.Users {
 	val, _ := configMap.Get("http_users")
 	v, ok := val.([]data.Users)
@@ -301,6 +683,10 @@ func SetActionsReload() {
 	configMap.Set("global_actions_reload", utils.TimeNow(GetConfigStr("global_timezone")))
 }
 
+func SetWorkflows(workflows map[string]data.Workflow) {
+	configMap.Set("workflows", workflows)
+}
+
 func SetConfigFile(file string) {
 	configMap.Set("global_config_file", file)
 }
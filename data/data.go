@@ -17,7 +17,12 @@
 
 package data
 
-import "time"
+import (
+	"time"
+
+	"github.com/goccy/go-json"
+	"gopkg.in/yaml.v3"
+)
 
 // HTTP Headers
 type Headers struct {
@@ -29,14 +34,37 @@ type Run struct {
 	Group  string `yaml:"group" json:"group"`
 	Action string `yaml:"action" json:"action"`
 	Input  string `yaml:"input" json:"input"`
+	When   When   `yaml:"when" json:"when"`
+	// AllowCycle marks a trigger that is part of an intentional cycle, it's logged instead of rejected on load
+	AllowCycle bool `yaml:"allow_cycle" json:"allow_cycle" validate:"boolean"`
+}
+
+// When limits a triggered run to results matching every condition set, an empty when always runs.
+// JSONField is a dot separated path into stdout parsed as JSON e.g. items.0.status, durations are in seconds
+type When struct {
+	Output      string `yaml:"output" json:"output"`
+	JSONField   string `yaml:"json_field" json:"json_field"`
+	JSONValue   string `yaml:"json_value" json:"json_value"`
+	MinDuration int    `yaml:"min_duration" json:"min_duration" validate:"number,min=0"`
+	MaxDuration int    `yaml:"max_duration" json:"max_duration" validate:"number,min=0"`
 }
 
 type OnError struct {
-	Notification  string   `yaml:"notification" json:"notification"`
-	Retries       int      `yaml:"retries" json:"retries" validate:"number"`
-	RetryInterval int      `yaml:"retry_interval" json:"retry_interval" validate:"number"`
-	Run           []Run    `yaml:"run" json:"run"`
-	Webhook       []string `yaml:"webhooks" json:"webhooks"`
+	Notification     string   `yaml:"notification" json:"notification"`
+	Retries          int      `yaml:"retries" json:"retries" validate:"number"`
+	RetryInterval    int      `yaml:"retry_interval" json:"retry_interval" validate:"number"`
+	RetryBackoff     float64  `yaml:"retry_backoff" json:"retry_backoff" validate:"number,min=0"`
+	RetryMaxInterval int      `yaml:"retry_max_interval" json:"retry_max_interval" validate:"number,min=0"`
+	RetryJitter      bool     `yaml:"retry_jitter" json:"retry_jitter" validate:"boolean"`
+	RetryOn          RetryOn  `yaml:"retry_on" json:"retry_on"`
+	Run              []Run    `yaml:"run" json:"run"`
+	Webhook          []string `yaml:"webhooks" json:"webhooks"`
+}
+
+// RetryOn limits retries to failed attempts with a matching exit code or output regex, empty retries every failure
+type RetryOn struct {
+	ExitCodes []int    `yaml:"exit_codes" json:"exit_codes"`
+	Output    []string `yaml:"output" json:"output"`
 }
 
 type OnSuccess struct {
@@ -48,6 +76,87 @@ type OnSuccess struct {
 type Container struct {
 	Image   string `yaml:"image" json:"image"`
 	Options string `yaml:"options" json:"options"`
+	// Stop is the command that kills the container of a run on cancel or timeout, set when the run starts
+	Stop []string `yaml:"-" json:"-"`
+}
+
+type Watch struct {
+	Paths     []string `yaml:"paths" json:"paths"`
+	UploadDir bool     `yaml:"upload_dir" json:"upload_dir" validate:"boolean"`
+	Glob      string   `yaml:"glob" json:"glob"`
+	Events    []string `yaml:"events" json:"events" validate:"dive,oneof=create modify delete"`
+	Debounce  int      `yaml:"debounce" json:"debounce" validate:"number,min=0"`
+}
+
+// Auth verifies a signature or token header of a request, e.g. signed webhooks from Git hosting
+type Auth struct {
+	Type            string `yaml:"type" json:"type" validate:"omitempty,oneof=hmac-sha256 hmac-sha1 token"`
+	Header          string `yaml:"header" json:"header" validate:"required_with=Type"`
+	Prefix          string `yaml:"prefix" json:"prefix"`
+	Encoding        string `yaml:"encoding" json:"encoding" validate:"omitempty,oneof=hex base64"`
+	Secret          string `yaml:"secret" json:"secret" validate:"excluded_with=SecretKey"`
+	SecretKey       string `yaml:"secret_key" json:"secret_key"`
+	Payload         string `yaml:"payload" json:"payload"`
+	TimestampHeader string `yaml:"timestamp_header" json:"timestamp_header"`
+	TimestampPrefix string `yaml:"timestamp_prefix" json:"timestamp_prefix"`
+	Tolerance       int    `yaml:"tolerance" json:"tolerance" validate:"number,min=0"`
+}
+
+// Param is a named input of an action, validated with the JSON Schema of all params and passed as PAL_PARAM_<NAME>
+type Param struct {
+	Name     string   `yaml:"name" json:"name" validate:"required,safestring"`
+	Type     string   `yaml:"type" json:"type" validate:"omitempty,oneof=string number integer boolean"`
+	Required bool     `yaml:"required" json:"required" validate:"boolean"`
+	Default  string   `yaml:"default" json:"default"`
+	Enum     []string `yaml:"enum" json:"enum"`
+	Regex    string   `yaml:"regex" json:"regex"`
+	Desc     string   `yaml:"desc" json:"desc"`
+}
+
+// Approval makes runs of an action wait as pending until an approver approves them
+type Approval struct {
+	Required  bool     `yaml:"required" json:"required" validate:"boolean"`
+	Approvers []string `yaml:"approvers" json:"approvers"`
+	Timeout   int      `yaml:"timeout" json:"timeout" validate:"number,min=0"`
+}
+
+// CronSchedule is a cron schedule of an action, a plain string is its cron expression only. With seconds the cron
+// has a leading seconds field, jitter delays each run by up to that many seconds and overlap is what to do when
+// the previous run is still going
+type CronSchedule struct {
+	Cron     string  `yaml:"cron" json:"cron" validate:"required"`
+	Seconds  bool    `yaml:"seconds" json:"seconds" validate:"boolean"`
+	Timezone string  `yaml:"timezone" json:"timezone" validate:"omitempty,timezone"`
+	Jitter   int     `yaml:"jitter" json:"jitter" validate:"number,min=0"`
+	Overlap  string  `yaml:"overlap" json:"overlap" validate:"omitempty,oneof=skip queue allow"`
+	Input    string  `yaml:"input" json:"input"`
+	Misfire  Misfire `yaml:"misfire" json:"misfire"`
+}
+
+type cronSchedule CronSchedule
+
+func (s *CronSchedule) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		*s = CronSchedule{}
+		return value.Decode(&s.Cron)
+	}
+
+	return value.Decode((*cronSchedule)(s))
+}
+
+func (s *CronSchedule) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		*s = CronSchedule{}
+		return json.Unmarshal(b, &s.Cron)
+	}
+
+	return json.Unmarshal(b, (*cronSchedule)(s))
+}
+
+// Misfire is what to do at startup with fires of a schedule missed while pal was down
+type Misfire struct {
+	Policy string `yaml:"policy" json:"policy" validate:"omitempty,oneof=skip run_once run_all"`
+	Limit  int    `yaml:"limit" json:"limit" validate:"number,min=0"`
 }
 
 type Triggers struct {
@@ -57,46 +166,64 @@ type Triggers struct {
 	TriggerAction    string `json:"trigger_action"`
 	TriggerCondition string `json:"trigger_condition"`
 	TriggerInput     string `json:"trigger_input"`
+	TriggerWhen      When   `json:"trigger_when"`
 }
 
 // ActionData struct for action data of a group
 type ActionData struct {
-	Group             string       `yaml:"-" json:"group"`
-	Desc              string       `yaml:"desc" json:"desc"`
-	Background        bool         `yaml:"background" json:"background" validate:"boolean"`
-	Action            string       `yaml:"action" json:"action" validate:"required,safestring"`
-	Concurrent        bool         `yaml:"concurrent" json:"concurrent" validate:"boolean"`
-	AuthHeader        string       `yaml:"auth_header" json:"auth_header"`
-	Output            bool         `yaml:"output" json:"output" validate:"boolean"`
-	Container         Container    `yaml:"container" json:"container"`
-	Timeout           int          `yaml:"timeout" json:"timeout" validate:"number"`
-	CmdPrefix         string       `yaml:"cmd_prefix" json:"cmd_prefix"`
-	Cmd               string       `yaml:"cmd" json:"cmd" validate:"required"`
-	ResponseHeaders   []Headers    `yaml:"headers" json:"headers"`
-	Schedule          []string     `yaml:"schedule" json:"schedule"`
-	OnError           OnError      `yaml:"on_error" json:"on_error"`
-	OnSuccess         OnSuccess    `yaml:"on_success" json:"on_success"`
-	Input             string       `yaml:"input" json:"input"`
-	InputValidate     string       `yaml:"input_validate" json:"input_validate"`
-	Register          DBSet        `yaml:"register" json:"register"`
-	Triggers          []Triggers   `yaml:"-" json:"triggers"`
-	LastRan           string       `yaml:"-" json:"last_ran"`
-	LastSuccess       string       `yaml:"-" json:"last_success"`
-	LastFailure       string       `yaml:"-" json:"last_failure"`
-	LastDuration      string       `yaml:"-" json:"last_duration"`
-	LastSuccessOutput string       `yaml:"-" json:"last_success_output"`
-	LastFailureOutput string       `yaml:"-" json:"last_failure_output"`
-	RunCount          int          `yaml:"-" json:"run_count"`
-	Status            string       `yaml:"-" json:"status"`
-	Disabled          bool         `yaml:"-" json:"disabled" validate:"boolean"`
-	Lock              bool         `yaml:"-" json:"-" validate:"boolean"`
-	RunHistory        []RunHistory `yaml:"-" json:"run_history"`
+	Group             string            `yaml:"-" json:"group"`
+	Desc              string            `yaml:"desc" json:"desc"`
+	Background        bool              `yaml:"background" json:"background" validate:"boolean"`
+	Action            string            `yaml:"action" json:"action" validate:"required,safestring"`
+	Concurrent        bool              `yaml:"concurrent" json:"concurrent" validate:"boolean"`
+	MaxConcurrent     int               `yaml:"max_concurrent" json:"max_concurrent" validate:"number,min=0"`
+	Queue             int               `yaml:"queue" json:"queue" validate:"number,min=0"`
+	AuthHeader        string            `yaml:"auth_header" json:"auth_header"`
+	Auth              Auth              `yaml:"auth" json:"auth"`
+	Approval          Approval          `yaml:"approval" json:"approval"`
+	AllowedWindows    []string          `yaml:"allowed_windows" json:"allowed_windows"`
+	BlockedWindows    []string          `yaml:"blocked_windows" json:"blocked_windows"`
+	Output            bool              `yaml:"output" json:"output" validate:"boolean"`
+	OutputFormat      string            `yaml:"output_format" json:"output_format" validate:"omitempty,oneof=text json"`
+	Container         Container         `yaml:"container" json:"container"`
+	Timeout           int               `yaml:"timeout" json:"timeout" validate:"number"`
+	CmdPrefix         string            `yaml:"cmd_prefix" json:"cmd_prefix"`
+	Cmd               string            `yaml:"cmd" json:"cmd" validate:"required_without=Args,excluded_with=Args"`
+	Args              []string          `yaml:"args" json:"args" validate:"required_without=Cmd"`
+	Env               map[string]string `yaml:"env" json:"env"`
+	EnvFile           string            `yaml:"env_file" json:"env_file"`
+	SuccessExitCodes  []int             `yaml:"success_exit_codes" json:"success_exit_codes"`
+	ResponseHeaders   []Headers         `yaml:"headers" json:"headers"`
+	Schedule          []CronSchedule    `yaml:"schedule" json:"schedule" validate:"dive"`
+	Watch             Watch             `yaml:"watch" json:"watch"`
+	OnError           OnError           `yaml:"on_error" json:"on_error"`
+	OnSuccess         OnSuccess         `yaml:"on_success" json:"on_success"`
+	Input             string            `yaml:"input" json:"input"`
+	InputValidate     string            `yaml:"input_validate" json:"input_validate"`
+	Params            []Param           `yaml:"params" json:"params" validate:"dive"`
+	Schema            map[string]any    `yaml:"-" json:"schema,omitempty"`
+	Register          DBSet             `yaml:"register" json:"register"`
+	Triggers          []Triggers        `yaml:"-" json:"triggers"`
+	LastRan           string            `yaml:"-" json:"last_ran"`
+	LastSuccess       string            `yaml:"-" json:"last_success"`
+	LastFailure       string            `yaml:"-" json:"last_failure"`
+	LastDuration      string            `yaml:"-" json:"last_duration"`
+	LastSuccessOutput string            `yaml:"-" json:"last_success_output"`
+	LastFailureOutput string            `yaml:"-" json:"last_failure_output"`
+	LastRunID         string            `yaml:"-" json:"last_run_id"`
+	LastExitCode      int               `yaml:"-" json:"last_exit_code"`
+	RunCount          int               `yaml:"-" json:"run_count"`
+	Status            string            `yaml:"-" json:"status"`
+	Disabled          bool              `yaml:"-" json:"disabled" validate:"boolean"`
+	RunHistory        []RunHistory      `yaml:"-" json:"run_history"`
 }
 
 type RunHistory struct {
+	ID       string `yaml:"-" json:"id"`
 	Ran      string `yaml:"-" json:"ran"`
 	Duration string `yaml:"-" json:"duration"`
 	Status   string `yaml:"-" json:"status"`
+	ExitCode int    `yaml:"-" json:"exit_code"`
 }
 
 type Users struct {
@@ -105,16 +232,176 @@ type Users struct {
 	Role string `yaml:"role"`
 }
 
+// RunRecord is a single execution of an action
+type RunRecord struct {
+	ID       string    `json:"id"`
+	Group    string    `json:"group"`
+	Action   string    `json:"action"`
+	Trigger  string    `json:"trigger"`
+	Input    string    `json:"input"`
+	Started  string    `json:"started"`
+	Ended    string    `json:"ended"`
+	Duration string    `json:"duration"`
+	ExitCode int       `json:"exit_code"`
+	Status   string    `json:"status"`
+	Output   string    `json:"output"`
+	Stdout   string    `json:"stdout"`
+	Stderr   string    `json:"stderr"`
+	Attempts []Attempt `json:"attempts"`
+	// QueuePosition and QueueLength are only set while the run is queued
+	QueuePosition int `json:"queue_position,omitempty"`
+	QueueLength   int `json:"queue_length,omitempty"`
+	// ParentRunID is the run that triggered the run, ChainID is the run ID that started a chain of triggered runs
+	// and Depth is how many triggers deep the run is
+	ParentRunID string `json:"parent_run_id"`
+	ChainID     string `json:"chain_id"`
+	Depth       int    `json:"depth"`
+	// Reason is why a triggered run was skipped, a pending run was rejected or a run was closed by a restart
+	Reason string `json:"reason,omitempty"`
+	// RequestedBy is the user that started a run pending approval and Approver the user that approved or denied it
+	RequestedBy string `json:"requested_by,omitempty"`
+	Approver    string `json:"approver,omitempty"`
+	// Workflow and Steps are only set on the run of a workflow
+	Workflow string    `json:"workflow,omitempty"`
+	Steps    []StepRun `json:"steps,omitempty"`
+}
+
+// StepRun is the status of a workflow step, RunID is the run record of the step action
+type StepRun struct {
+	Name     string   `json:"name"`
+	Group    string   `json:"group"`
+	Action   string   `json:"action"`
+	Needs    []string `json:"needs"`
+	Status   string   `json:"status"`
+	RunID    string   `json:"run_id"`
+	Started  string   `json:"started"`
+	Ended    string   `json:"ended"`
+	Duration string   `json:"duration"`
+	ExitCode int      `json:"exit_code"`
+}
+
+// Attempt is a single try of a run, a run has more than one when on_error retries are set
+type Attempt struct {
+	Attempt  int    `json:"attempt"`
+	Duration string `json:"duration"`
+	ExitCode int    `json:"exit_code"`
+	Status   string `json:"status"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+}
+
+// RunningActions is the worker pool occupancy with the active and queued runs by action
+type RunningActions struct {
+	Workers Workers         `json:"workers"`
+	Actions []RunningAction `json:"actions"`
+}
+
+// Workers is the occupancy of the global.max_workers pool, max is 0 when unlimited
+type Workers struct {
+	Max     int `json:"max"`
+	Busy    int `json:"busy"`
+	Waiting int `json:"waiting"`
+}
+
+// RunningAction is the active and queued runs of an action, limit is 0 when unlimited
+type RunningAction struct {
+	Group       string      `json:"group"`
+	Action      string      `json:"action"`
+	Limit       int         `json:"limit"`
+	Running     []string    `json:"running"`
+	Queue       []QueuedRun `json:"queue"`
+	QueueLength int         `json:"queue_length"`
+}
+
+// QueuedRun is a run waiting on the lock of a non-concurrent action
+type QueuedRun struct {
+	ID       string `json:"id"`
+	Position int    `json:"position"`
+}
+
+// CmdResult is the captured result of running a cmd
+type CmdResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Duration string
+	Attempts []Attempt
+}
+
+// Workflow is a DAG of steps that run existing group/actions
+type Workflow struct {
+	Name       string         `yaml:"name" json:"name" validate:"required,safestring"`
+	Desc       string         `yaml:"desc" json:"desc"`
+	AuthHeader string         `yaml:"auth_header" json:"auth_header"`
+	Input      string         `yaml:"input" json:"input"`
+	Steps      []WorkflowStep `yaml:"steps" json:"steps" validate:"required,min=1,dive"`
+}
+
+// WorkflowStep runs a group/action once the steps it needs are done and its if condition holds
+type WorkflowStep struct {
+	Name   string   `yaml:"name" json:"name" validate:"required,safestring"`
+	Group  string   `yaml:"group" json:"group" validate:"required"`
+	Action string   `yaml:"action" json:"action" validate:"required"`
+	Input  string   `yaml:"input" json:"input"`
+	Needs  []string `yaml:"needs" json:"needs"`
+	If     string   `yaml:"if" json:"if" validate:"omitempty,oneof=success failure always"`
+}
+
+// GroupConfig is the pal.yml settings shared by every action of a group
+type GroupConfig struct {
+	Env            map[string]string `yaml:"env"`
+	EnvFile        string            `yaml:"env_file"`
+	AllowedWindows []string          `yaml:"allowed_windows"`
+	BlockedWindows []string          `yaml:"blocked_windows"`
+}
+
+// Calendar is a named set of date ranges, weekday time windows and iCal holidays actions are allowed or blocked to run in
+type Calendar struct {
+	Timezone string      `yaml:"timezone" validate:"omitempty,timezone"`
+	Dates    []DateRange `yaml:"dates" validate:"dive"`
+	Windows  []Window    `yaml:"windows" validate:"dive"`
+	ICal     string      `yaml:"ical" validate:"omitempty,file"`
+	Holidays []Holiday   `yaml:"-"`
+}
+
+// DateRange is from the start to the end date inclusive, dates are YYYY-MM-DD or RFC3339 times
+type DateRange struct {
+	Start string `yaml:"start" validate:"required"`
+	End   string `yaml:"end"`
+}
+
+// Window is a time of day window on weekdays, an end before the start ends on the next day
+type Window struct {
+	Days  []string `yaml:"days" validate:"dive,oneof=mon tue wed thu fri sat sun"`
+	Start string   `yaml:"start"`
+	End   string   `yaml:"end"`
+}
+
+// Holiday is an event of an iCal file, yearly events repeat every year
+type Holiday struct {
+	Summary string
+	Start   time.Time
+	End     time.Time
+	Yearly  bool
+}
+
 // Config
 type Config struct {
 	Global struct {
-		Timezone     string `yaml:"timezone"`
-		CmdPrefix    string `yaml:"cmd_prefix"`
-		ContainerCmd string `yaml:"container_cmd"`
-		WorkingDir   string `yaml:"working_dir"`
-		Debug        bool   `yaml:"debug" validate:"boolean"`
+		Timezone     string            `yaml:"timezone"`
+		CmdPrefix    string            `yaml:"cmd_prefix"`
+		ContainerCmd string            `yaml:"container_cmd"`
+		WorkingDir   string            `yaml:"working_dir"`
+		Debug        bool              `yaml:"debug" validate:"boolean"`
+		MaxWorkers   int               `yaml:"max_workers" validate:"number,min=0"`
+		MaxDepth     int               `yaml:"max_chain_depth" validate:"number,min=0"`
+		Env          map[string]string `yaml:"env"`
+		EnvFile      string            `yaml:"env_file"`
+		Templates    bool              `yaml:"templates" validate:"boolean"`
 	} `yaml:"global"`
-	HTTP struct {
+	Groups    map[string]GroupConfig `yaml:"groups"`
+	Calendars map[string]Calendar    `yaml:"calendars" validate:"dive"`
+	HTTP      struct {
 		Listen          string    `yaml:"listen" validate:"required"`
 		TimeoutMin      int       `yaml:"timeout_min" validate:"number"`
 		BodyLimit       int       `yaml:"body_limit" validate:"number"`
@@ -139,6 +426,16 @@ type Config struct {
 		StoreMax int       `yaml:"store_max" validate:"number"`
 		Webhooks []Webhook `yaml:"webhooks" json:"webhooks"`
 	} `yaml:"notifications"`
+	Runs struct {
+		StoreMax      int `yaml:"store_max" validate:"number"`
+		RetentionDays int `yaml:"retention_days" validate:"number"`
+	} `yaml:"runs"`
+	Leader struct {
+		Type      string `yaml:"type" validate:"omitempty,oneof=file"`
+		LeaseFile string `yaml:"lease_file" validate:"required_if=Type file"`
+		Node      string `yaml:"node"`
+		TTL       int    `yaml:"ttl" validate:"number,min=0"`
+	} `yaml:"leader"`
 }
 
 type Webhook struct {
@@ -158,6 +455,43 @@ type Schedule struct {
 	NextRun      time.Time `json:"next_run"`
 	Group        string    `json:"group"`
 	Action       string    `json:"action"`
+	ID           string    `json:"id,omitempty"`
+	// Cron is only set on cron schedules
+	Cron   string `json:"cron,omitempty"`
+	Input  string `json:"input,omitempty"`
+	Paused bool   `json:"paused"`
+	OneOff bool   `json:"one_off"`
+}
+
+// SchedulePreview is the next fire times of a schedule or a cron expression
+type SchedulePreview struct {
+	ID       string      `json:"id,omitempty"`
+	Group    string      `json:"group,omitempty"`
+	Action   string      `json:"action,omitempty"`
+	Cron     string      `json:"cron"`
+	Seconds  bool        `json:"seconds"`
+	Timezone string      `json:"timezone"`
+	Paused   bool        `json:"paused"`
+	NextRuns []time.Time `json:"next_runs"`
+}
+
+// ScheduleSync is the cron schedules added, removed and updated by resyncing the scheduler, as group/action cron
+type ScheduleSync struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Updated []string `json:"updated"`
+	Err     string   `json:"err,omitempty"`
+}
+
+// OneOff is a single future run of an action scheduled with at or delay
+type OneOff struct {
+	ID        string    `json:"id"`
+	Group     string    `json:"group"`
+	Action    string    `json:"action"`
+	Input     string    `json:"input"`
+	At        time.Time `json:"at"`
+	Created   string    `json:"created"`
+	CreatedBy string    `json:"created_by,omitempty"`
 }
 
 // GenericResponse
@@ -18,11 +18,14 @@
 package db
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	badger "github.com/dgraph-io/badger/v4"
@@ -32,19 +35,83 @@ import (
 	"github.com/marshyski/pal/data"
 )
 
-// indexCacheSize = 100MB
-const indexCacheSize = 100 << 20
+const (
+	// indexCacheSize = 100MB
+	indexCacheSize = 100 << 20
+	runsPrefix     = "pal_runs_"
+	oneOffsPrefix  = "pal_oneoffs_"
+	firesPrefix    = "pal_fires_"
+	pausedPrefix   = "pal_paused_"
+	hoursPerDay    = 24
+	// pruneInterval is how often run records over runs.store_max are deleted
+	pruneInterval = time.Minute
+)
 
 var (
 	DBC    = &DB{}
 	runMgr = &RunningManager{
 		counts: make(map[string]int),
+		runs:   make(map[string]runningRun),
+	}
+	queueMgr = &QueueManager{
+		active: make(map[string]int),
+		queues: make(map[string][]queuedRun),
+	}
+	workerPool  = &WorkerPool{}
+	approvalMgr = &ApprovalManager{
+		pending: make(map[string]chan Approval),
+		decided: make(map[string]bool),
 	}
+
+	// ErrRunCancelled is the cancel cause of a run stopped by CancelRunning
+	ErrRunCancelled = errors.New("error run cancelled")
+	// ErrNotReady is returned by Acquire when the action is at its limit and has no queue
+	ErrNotReady = errors.New("error not ready")
+	// ErrQueueFull is returned by Acquire when the action queue is at max depth
+	ErrQueueFull = errors.New("error queue is full")
 )
 
 type RunningManager struct {
 	mu     sync.RWMutex
 	counts map[string]int
+	runs   map[string]runningRun
+}
+
+type runningRun struct {
+	action string
+	cancel context.CancelCauseFunc
+}
+
+// QueueManager limits how many runs of an action are active at once and holds the FIFO queue of runs waiting on a slot
+type QueueManager struct {
+	mu     sync.Mutex
+	active map[string]int
+	queues map[string][]queuedRun
+}
+
+type queuedRun struct {
+	id    string
+	ready chan bool
+}
+
+// WorkerPool limits how many actions run at once across every action, set with global.max_workers
+type WorkerPool struct {
+	once    sync.Once
+	slots   chan struct{}
+	waiting atomic.Int64
+}
+
+// ApprovalManager holds the runs pending approval until their decision is received
+type ApprovalManager struct {
+	mu      sync.Mutex
+	pending map[string]chan Approval
+	decided map[string]bool
+}
+
+// Approval is the decision of a user on a pending run
+type Approval struct {
+	Approved bool
+	User     string
 }
 
 type DB struct {
@@ -53,7 +120,7 @@ type DB struct {
 
 // getRestrictedKeys gets a constant string slice
 func getRestrictedKeys() []string {
-	return []string{"pal_notifications", "pal_groups"}
+	return []string{"pal_notifications", "pal_groups", "pal_runs", "pal_oneoffs", "pal_fires", "pal_paused"}
 }
 
 func Open() (*DB, error) {
@@ -78,6 +145,7 @@ func Open() (*DB, error) {
 	DBC = &DB{
 		badgerDB: badgerDB,
 	}
+	go DBC.pruneLoop()
 
 	return &DB{
 		badgerDB: badgerDB,
@@ -218,7 +286,7 @@ func (s *DB) DeleteNotifications() error {
 
 func (s *DB) Put(dbSet data.DBSet) error {
 	for _, e := range getRestrictedKeys() {
-		if e == dbSet.Key {
+		if strings.HasPrefix(dbSet.Key, e) {
 			return fmt.Errorf("failed to add value to key %s due to restricted key denied", dbSet.Key)
 		}
 	}
@@ -425,6 +493,289 @@ func (s *DB) Dump() []data.DBSet {
 	return dbSetSlice
 }
 
+// PutRun creates or updates a run record, the oldest over runs.store_max are pruned in the background
+func (s *DB) PutRun(run data.RunRecord) error {
+	key := runsPrefix + run.ID
+	jsonData, err := json.Marshal(run)
+	if err != nil {
+		return errors.New("failed to marshal JSON for key: " + key)
+	}
+
+	err = s.badgerDB.Update(func(txn *badger.Txn) error {
+		entry := badger.NewEntry([]byte(key), jsonData)
+		if days := config.GetConfigInt("runs_retention_days"); days > 0 {
+			entry = entry.WithTTL(time.Duration(days) * hoursPerDay * time.Hour)
+		}
+		return txn.SetEntry(entry)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set state for key: %s - %w", key, err)
+	}
+
+	return nil
+}
+
+// pruneLoop prunes run records every pruneInterval off the write path until the DB is closed
+func (s *DB) pruneLoop() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if s.badgerDB.IsClosed() {
+			return
+		}
+		if err := s.pruneRuns(config.GetConfigInt("runs_store_max")); err != nil {
+			log.Println(err.Error())
+		}
+	}
+}
+
+// pruneRuns deletes the oldest run records, keys are time ordered UUIDv7
+func (s *DB) pruneRuns(storeMax int) error {
+	var keys [][]byte
+
+	err := s.badgerDB.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = []byte(runsPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list run records - %w", err)
+	}
+
+	if storeMax <= 0 || len(keys) <= storeMax {
+		return nil
+	}
+
+	return s.badgerDB.Update(func(txn *badger.Txn) error {
+		for _, k := range keys[:len(keys)-storeMax] {
+			if err := txn.Delete(k); err != nil {
+				return fmt.Errorf("failed to delete run record: %s - %w", k, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s *DB) GetRun(id string) (data.RunRecord, error) {
+	var run data.RunRecord
+
+	err := s.badgerDB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(runsPrefix + id))
+		if err != nil {
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &run)
+		})
+	})
+	if err != nil {
+		return run, fmt.Errorf("failed to get run record: %s - %w", id, err)
+	}
+
+	return run, nil
+}
+
+// GetRuns returns run records newest first, empty filters match everything
+func (s *DB) GetRuns(group, action, workflow, status string, since time.Time) []data.RunRecord {
+	runs := []data.RunRecord{}
+
+	err := s.badgerDB.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(runsPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			var run data.RunRecord
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &run)
+			})
+			if err != nil {
+				continue
+			}
+
+			if (group != "" && run.Group != group) ||
+				(action != "" && run.Action != action) ||
+				(workflow != "" && run.Workflow != workflow) ||
+				(status != "" && run.Status != status) {
+				continue
+			}
+
+			if !since.IsZero() {
+				started, err := time.Parse(time.RFC3339, run.Started)
+				if err != nil || started.Before(since) {
+					continue
+				}
+			}
+
+			runs = append(runs, run)
+		}
+		return nil
+	})
+	if err != nil {
+		// TODO: DEBUG STATEMENT
+		log.Println(err.Error())
+	}
+
+	slices.Reverse(runs)
+
+	return runs
+}
+
+// PutOneOff stores a one-off schedule so it survives restarts
+func (s *DB) PutOneOff(oneOff data.OneOff) error {
+	key := oneOffsPrefix + oneOff.ID
+	jsonData, err := json.Marshal(oneOff)
+	if err != nil {
+		return errors.New("failed to marshal JSON for key: " + key)
+	}
+
+	err = s.badgerDB.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(key), jsonData)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set state for key: %s - %w", key, err)
+	}
+
+	return nil
+}
+
+func (s *DB) GetOneOff(id string) (data.OneOff, error) {
+	var oneOff data.OneOff
+
+	err := s.badgerDB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(oneOffsPrefix + id))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			return json.Unmarshal(val, &oneOff)
+		})
+	})
+	if err != nil {
+		return oneOff, fmt.Errorf("failed to get one-off schedule: %s - %w", id, err)
+	}
+
+	return oneOff, nil
+}
+
+// GetOneOffs returns every stored one-off schedule
+func (s *DB) GetOneOffs() []data.OneOff {
+	oneOffs := []data.OneOff{}
+
+	err := s.badgerDB.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(oneOffsPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			var oneOff data.OneOff
+			err := it.Item().Value(func(val []byte) error {
+				return json.Unmarshal(val, &oneOff)
+			})
+			if err != nil {
+				continue
+			}
+			oneOffs = append(oneOffs, oneOff)
+		}
+		return nil
+	})
+	if err != nil {
+		// TODO: DEBUG STATEMENT
+		log.Println(err.Error())
+	}
+
+	return oneOffs
+}
+
+func (s *DB) DeleteOneOff(id string) error {
+	return s.badgerDB.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(oneOffsPrefix + id))
+	})
+}
+
+// PutLastFire stores the last time a schedule fired, or was checked for misfires
+func (s *DB) PutLastFire(schedule string, t time.Time) error {
+	key := firesPrefix + schedule
+	err := s.badgerDB.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(key), []byte(t.Format(time.RFC3339)))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to set state for key: %s - %w", key, err)
+	}
+
+	return nil
+}
+
+// GetLastFire returns the last fire time of a schedule, zero if it never fired
+func (s *DB) GetLastFire(schedule string) time.Time {
+	var last time.Time
+
+	err := s.badgerDB.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(firesPrefix + schedule))
+		if err != nil {
+			return err
+		}
+		return item.Value(func(val []byte) error {
+			last, err = time.Parse(time.RFC3339, string(val))
+			return err
+		})
+	})
+	if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+		// TODO: DEBUG STATEMENT
+		log.Println(err.Error())
+	}
+
+	return last
+}
+
+// PutPaused pauses the schedules of a key, the scheduler, a group or a schedule
+func (s *DB) PutPaused(key string) error {
+	err := s.badgerDB.Update(func(txn *badger.Txn) error {
+		return txn.Set([]byte(pausedPrefix+key), []byte(time.Now().Format(time.RFC3339)))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to pause schedules: %s - %w", key, err)
+	}
+
+	return nil
+}
+
+func (s *DB) DeletePaused(key string) error {
+	return s.badgerDB.Update(func(txn *badger.Txn) error {
+		return txn.Delete([]byte(pausedPrefix + key))
+	})
+}
+
+// GetPaused returns the keys of paused schedules
+func (s *DB) GetPaused() map[string]bool {
+	paused := make(map[string]bool)
+
+	err := s.badgerDB.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(pausedPrefix)
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			paused[strings.TrimPrefix(string(it.Item().Key()), pausedPrefix)] = true
+		}
+		return nil
+	})
+	if err != nil {
+		// TODO: DEBUG STATEMENT
+		log.Println(err.Error())
+	}
+
+	return paused
+}
+
 func GetRunning() []string {
 	runMgr.mu.RLock()
 	defer runMgr.mu.RUnlock()
@@ -438,17 +789,20 @@ func GetRunning() []string {
 	return running
 }
 
-func PutRunning(action string) {
+func PutRunning(action, id string, cancel context.CancelCauseFunc) {
 	runMgr.mu.Lock()
 	defer runMgr.mu.Unlock()
 
 	runMgr.counts[action]++
+	runMgr.runs[id] = runningRun{action: action, cancel: cancel}
 }
 
-func DeleteRunning(action string) {
+func DeleteRunning(action, id string) {
 	runMgr.mu.Lock()
 	defer runMgr.mu.Unlock()
 
+	delete(runMgr.runs, id)
+
 	if runMgr.counts[action] > 0 {
 		runMgr.counts[action]--
 		if runMgr.counts[action] == 0 {
@@ -466,6 +820,36 @@ func IsRunning(action string) bool {
 	return exists && count > 0
 }
 
+// GetRunningIDs returns the run ids of every active instance of the action
+func GetRunningIDs(action string) []string {
+	runMgr.mu.RLock()
+	defer runMgr.mu.RUnlock()
+
+	ids := []string{}
+	for id, run := range runMgr.runs {
+		if run.action == action {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+
+	return ids
+}
+
+// CancelRunning cancels an active run by id, returns false if the run is not running
+func CancelRunning(id string) bool {
+	runMgr.mu.RLock()
+	defer runMgr.mu.RUnlock()
+
+	run, exists := runMgr.runs[id]
+	if !exists {
+		return false
+	}
+	run.cancel(ErrRunCancelled)
+
+	return true
+}
+
 // GetCount returns exactly how many instances of an action are running
 func GetCount(action string) int {
 	runMgr.mu.RLock()
@@ -473,3 +857,197 @@ func GetCount(action string) int {
 
 	return runMgr.counts[action]
 }
+
+// Acquire takes one of the limit slots of the action for a run, when every slot is taken the run is added to the
+// end of the FIFO queue up to depth. The returned channel is nil when a slot was taken, otherwise it receives true
+// once a slot is handed to the queued run or false when the queued run is cancelled
+func Acquire(action, id string, limit, depth int) (<-chan bool, error) {
+	queueMgr.mu.Lock()
+	defer queueMgr.mu.Unlock()
+
+	if queueMgr.active[action] < limit {
+		queueMgr.active[action]++
+		return nil, nil
+	}
+
+	if depth == 0 {
+		return nil, ErrNotReady
+	}
+
+	if len(queueMgr.queues[action]) >= depth {
+		return nil, ErrQueueFull
+	}
+
+	ready := make(chan bool, 1)
+	queueMgr.queues[action] = append(queueMgr.queues[action], queuedRun{id: id, ready: ready})
+
+	return ready, nil
+}
+
+// Release hands the slot of a finished run to the next queued run of the action, returns true if the queue was empty and the slot is freed
+func Release(action string) bool {
+	queueMgr.mu.Lock()
+	defer queueMgr.mu.Unlock()
+
+	queue := queueMgr.queues[action]
+	if len(queue) == 0 {
+		if queueMgr.active[action] > 0 {
+			queueMgr.active[action]--
+		}
+		if queueMgr.active[action] == 0 {
+			delete(queueMgr.active, action)
+		}
+		return true
+	}
+
+	queue[0].ready <- true
+	if len(queue) == 1 {
+		delete(queueMgr.queues, action)
+	} else {
+		queueMgr.queues[action] = queue[1:]
+	}
+
+	return false
+}
+
+// CancelQueued removes a queued run by id, returns false if the run is not queued
+func CancelQueued(id string) bool {
+	queueMgr.mu.Lock()
+	defer queueMgr.mu.Unlock()
+
+	for action, queue := range queueMgr.queues {
+		for i, run := range queue {
+			if run.id == id {
+				run.ready <- false
+				queue = slices.Delete(queue, i, i+1)
+				if len(queue) == 0 {
+					delete(queueMgr.queues, action)
+				} else {
+					queueMgr.queues[action] = queue
+				}
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// GetQueuePosition returns the 1-based queue position and the queue length of a queued run, 0 if it's not queued
+func GetQueuePosition(id string) (int, int) {
+	queueMgr.mu.Lock()
+	defer queueMgr.mu.Unlock()
+
+	for _, queue := range queueMgr.queues {
+		for i, run := range queue {
+			if run.id == id {
+				return i + 1, len(queue)
+			}
+		}
+	}
+
+	return 0, 0
+}
+
+// GetQueued returns the run ids queued for the action in FIFO order
+func GetQueued(action string) []string {
+	queueMgr.mu.Lock()
+	defer queueMgr.mu.Unlock()
+
+	ids := []string{}
+	for _, run := range queueMgr.queues[action] {
+		ids = append(ids, run.id)
+	}
+
+	return ids
+}
+
+func initWorkers() {
+	workerPool.once.Do(func() {
+		if maxWorkers := config.GetConfigInt("global_max_workers"); maxWorkers > 0 {
+			workerPool.slots = make(chan struct{}, maxWorkers)
+		}
+	})
+}
+
+// AcquireWorker blocks until a global worker is free or ctx is done, returns right away when global.max_workers is not set
+func AcquireWorker(ctx context.Context) error {
+	initWorkers()
+	if workerPool.slots == nil {
+		return nil
+	}
+
+	workerPool.waiting.Add(1)
+	defer workerPool.waiting.Add(-1)
+
+	select {
+	case workerPool.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// ReleaseWorker frees a global worker taken by AcquireWorker
+func ReleaseWorker() {
+	if workerPool.slots != nil {
+		<-workerPool.slots
+	}
+}
+
+// GetWorkers returns the global worker pool size, busy and waiting runs, size is 0 when unlimited
+func GetWorkers() (int, int, int) {
+	initWorkers()
+
+	return cap(workerPool.slots), len(workerPool.slots), int(workerPool.waiting.Load())
+}
+
+// PutApproval registers a run pending approval, the returned channel receives the decision
+func PutApproval(id string) <-chan Approval {
+	approvalMgr.mu.Lock()
+	defer approvalMgr.mu.Unlock()
+
+	decision := make(chan Approval, 1)
+	approvalMgr.pending[id] = decision
+
+	return decision
+}
+
+// GetApproval returns the decision channel of a registered run, it's kept after the decision until DeleteApproval
+func GetApproval(id string) (<-chan Approval, bool) {
+	approvalMgr.mu.Lock()
+	defer approvalMgr.mu.Unlock()
+
+	decision, ok := approvalMgr.pending[id]
+
+	return decision, ok
+}
+
+// DecideApproval hands a decision to a pending run, returns false if the run is not pending or already decided on
+func DecideApproval(id string, approval Approval) bool {
+	approvalMgr.mu.Lock()
+	defer approvalMgr.mu.Unlock()
+
+	decision, ok := approvalMgr.pending[id]
+	if !ok || approvalMgr.decided[id] {
+		return false
+	}
+	decision <- approval
+	approvalMgr.decided[id] = true
+
+	return true
+}
+
+// DeleteApproval removes a registered run e.g. on timeout or once its decision is received, returns false if the
+// run wasn't pending or was already decided on and the decision is waiting in its channel
+func DeleteApproval(id string) bool {
+	approvalMgr.mu.Lock()
+	defer approvalMgr.mu.Unlock()
+
+	_, ok := approvalMgr.pending[id]
+	decided := approvalMgr.decided[id]
+	delete(approvalMgr.pending, id)
+	delete(approvalMgr.decided, id)
+
+	return ok && !decided
+}
@@ -18,6 +18,8 @@ if [ ! -f "$PAL_CONFIG_FILE" ]; then
     GLOBAL_CMD_PREFIX="${GLOBAL_CMD_PREFIX:-/bin/sh -c}"
     GLOBAL_WORKDIR="${GLOBAL_WORKDIR:-/pal}"
     GLOBAL_DEBUG="${GLOBAL_DEBUG:-false}"
+    GLOBAL_MAX_WORKERS="${GLOBAL_MAX_WORKERS:-0}"
+    GLOBAL_MAX_CHAIN_DEPTH="${GLOBAL_MAX_CHAIN_DEPTH:-10}"
 
     HTTP_LISTEN="${HTTP_LISTEN:-0.0.0.0:8443}"
     HTTP_IPV6="${HTTP_IPV6:-false}"
@@ -62,6 +64,9 @@ MSG
     NOTIFICATIONS_STORE_MAX="${NOTIFICATIONS_STORE_MAX:-100}"
     NOTIFICATIONS_WEBHOOKS="${NOTIFICATIONS_WEBHOOKS:-[]}"
 
+    RUNS_STORE_MAX="${RUNS_STORE_MAX:-1000}"
+    RUNS_RETENTION_DAYS="${RUNS_RETENTION_DAYS:-30}"
+
     mkdir -p \
         "$PAL_CONFIG_DIR" \
         "$PAL_ACTIONS_DIR" \
@@ -76,6 +81,8 @@ global:
   cmd_prefix: "$GLOBAL_CMD_PREFIX"
   working_dir: "$GLOBAL_WORKDIR"
   debug: $GLOBAL_DEBUG
+  max_workers: $GLOBAL_MAX_WORKERS
+  max_chain_depth: $GLOBAL_MAX_CHAIN_DEPTH
 http:
   listen: "$HTTP_LISTEN"
   ipv6: $HTTP_IPV6
@@ -98,6 +105,9 @@ db:
 notifications:
   store_max: $NOTIFICATIONS_STORE_MAX
   webhooks: $NOTIFICATIONS_WEBHOOKS
+runs:
+  store_max: $RUNS_STORE_MAX
+  retention_days: $RUNS_RETENTION_DAYS
 EOF
     chmod -f 0400 "$PAL_CONFIG_FILE"
 fi
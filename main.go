@@ -177,6 +177,12 @@ Documentation:	https://github.com/marshyski/pal
 	}
 
 	groups := config.ReadConfig(actionsDir)
+	workflows := config.ReadWorkflows(actionsDir, groups)
+
+	// Trigger cycles fail startup the same as validating or reloading actions
+	if err := config.ValidateTriggers(groups); err != nil {
+		log.Fatalln(err.Error())
+	}
 
 	if validateActions {
 		log.Println("Actions validated")
@@ -186,6 +192,7 @@ Documentation:	https://github.com/marshyski/pal
 	config.SetVersion(version)
 	config.SetGoVersion(goVer)
 	config.SetActionsDir(actionsDir)
+	config.SetWorkflows(workflows)
 
 	// keep need it twice for init/now and ReloadActions
 	for k, v := range groups {
@@ -209,10 +216,13 @@ Documentation:	https://github.com/marshyski/pal
 
 	err = routes.ReloadActions(groups)
 	if err != nil {
-		log.Println("error reloading actions")
+		log.Println("error reloading actions " + err.Error())
 	}
 	config.SetActionsReload()
 
+	routes.RejectPending()
+	routes.CloseInterrupted()
+
 	groups = db.DBC.GetGroups()
 
 	// Setup Scheduled Schedule Type Cmds
@@ -221,6 +231,12 @@ Documentation:	https://github.com/marshyski/pal
 		defer log.Fatalln(err.Error())
 	}
 
+	// Setup Watch Type Cmds
+	err = routes.WatchStart(groups)
+	if err != nil {
+		log.Println("error starting watches " + err.Error())
+	}
+
 	e := echo.New()
 	// e.Debug = config.GetConfigBool("global_debug")
 	// e.HideBanner = true
@@ -300,6 +316,10 @@ Documentation:	https://github.com/marshyski/pal
 	e.DELETE("/v1/pal/db/delete", routes.DeleteDBDel)
 	e.GET("/v1/pal/health", routes.GetHealth)
 	e.GET("/v1/pal/schedules", routes.GetSchedulesJSON)
+	e.DELETE("/v1/pal/schedules/:id", routes.DeleteSchedule)
+	e.GET("/v1/pal/schedules/preview", routes.GetSchedulePreview)
+	e.POST("/v1/pal/schedules/pause", routes.PauseSchedules)
+	e.POST("/v1/pal/schedules/resume", routes.PauseSchedules)
 	e.GET("/v1/pal/notifications", routes.GetNotifications)
 	e.PUT("/v1/pal/notifications", routes.PutNotifications)
 	e.GET("/v1/pal/run/:group/:action", routes.RunGroup)
@@ -307,6 +327,15 @@ Documentation:	https://github.com/marshyski/pal
 	e.GET("/v1/pal/actions", routes.GetActions)
 	e.GET("/v1/pal/action", routes.GetAction)
 	e.GET("/v1/pal/actions/running", routes.GetRunning)
+	e.POST("/v1/pal/actions/reload", routes.GetReloadActions)
+	e.GET("/v1/pal/runs", routes.GetRuns)
+	e.GET("/v1/pal/runs/:id", routes.GetRun)
+	e.DELETE("/v1/pal/runs/:id", routes.CancelRun)
+	e.POST("/v1/pal/runs/:id/approve", routes.DecideRun)
+	e.POST("/v1/pal/runs/:id/deny", routes.DecideRun)
+	e.GET("/v1/pal/workflows", routes.GetWorkflows)
+	e.GET("/v1/pal/workflows/:name/run", routes.RunWorkflow)
+	e.POST("/v1/pal/workflows/:name/run", routes.RunWorkflow)
 
 	if !config.GetConfigBool("http_disable_ui") {
 		uiFS, err := fs.Sub(ui.UIFiles, ".")
@@ -321,6 +350,7 @@ Documentation:	https://github.com/marshyski/pal
 		template.Must(tmpl.New("action.tmpl").ParseFS(uiFS, "action.tmpl"))
 		template.Must(tmpl.New("system.tmpl").ParseFS(uiFS, "system.tmpl"))
 		template.Must(tmpl.New("notifications.tmpl").ParseFS(uiFS, "notifications.tmpl"))
+		template.Must(tmpl.New("workflows.tmpl").ParseFS(uiFS, "workflows.tmpl"))
 		actionsFuncMap := template.FuncMap{
 			"getData": func() map[string][]data.ActionData {
 				return groups
@@ -386,10 +416,17 @@ Documentation:	https://github.com/marshyski/pal
 		e.GET("/v1/pal/ui/notifications", routes.GetNotificationsPage)
 		e.GET("/v1/pal/ui/notifications/delete", routes.GetDeleteNotifications)
 		e.GET("/v1/pal/ui/schedules", routes.GetSchedules)
+		e.GET("/v1/pal/ui/schedules/:id/delete", routes.DeleteSchedule)
+		e.GET("/v1/pal/ui/schedules/pause", routes.PauseSchedules)
+		e.GET("/v1/pal/ui/schedules/resume", routes.PauseSchedules)
+		e.GET("/v1/pal/ui/workflows", routes.GetWorkflowsPage)
+		e.GET("/v1/pal/ui/workflows/:name/run", routes.RunWorkflow)
 		e.GET("/v1/pal/ui/action/:group/:action", routes.GetActionPage)
 		e.POST("/v1/pal/ui/action/:group/:action/run", routes.RunGroup)
 		e.GET("/v1/pal/ui/action/:group/:action/run", routes.RunGroup)
 		e.GET("/v1/pal/ui/action/:group/:action/reset_runs", routes.GetResetAction)
+		e.GET("/v1/pal/ui/runs/:id/approve", routes.DecideRun)
+		e.GET("/v1/pal/ui/runs/:id/deny", routes.DecideRun)
 		e.GET("/v1/pal/ui/logout", routes.GetLogout)
 	}
 
@@ -19,25 +19,39 @@ package routes
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"crypto/fips140"
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // HMAC-SHA1 signatures of webhooks
+	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
 	"log"
+	mathrand "math/rand/v2"
 	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	txttemplate "text/template"
 	"time"
 
 	"github.com/dustin/go-humanize"
+	"github.com/fsnotify/fsnotify"
 	"github.com/go-co-op/gocron/v2"
 	"github.com/go-playground/validator/v10"
 	"github.com/goccy/go-json"
@@ -51,24 +65,60 @@ import (
 	"github.com/marshyski/pal/db"
 	"github.com/marshyski/pal/ui"
 	"github.com/marshyski/pal/utils"
+	"github.com/santhosh-tekuri/jsonschema/v6"
 	"golang.org/x/net/http2"
 	"gopkg.in/yaml.v3"
 )
 
 const (
-	copyBufSize       = 256 << 10 // 256KB copy buffer
-	httpClientTimeout = 15
-	runHistoryLimit   = 5
-	errorAuth         = "error unauthorized"
-	errorScript       = "error script fail"
-	errorNotReady     = "error not ready"
-	errorAction       = "error invalid action"
-	errorGroup        = "error group invalid"
-	favicon           = `<svg width="32" height="32" viewBox="0 0 32 32" fill="none" xmlns="http://www.w3.org/2000/svg"><rect width="32" height="32" rx="4" fill="#2D333B"/><g fill="white"><rect x="6" y="5" width="20" height="3" rx="1.5"/><rect x="6" y="9" width="8" height="3" rx="1.5"/><rect x="18" y="9" width="8" height="3" rx="1.5"/><rect x="6" y="13" width="8" height="3" rx="1.5"/><rect x="18" y="13" width="8" height="3" rx="1.5"/><rect x="6" y="17" width="18" height="3" rx="1.5"/><rect x="6" y="21" width="6" height="3" rx="1.5"/><rect x="6" y="25" width="6" height="3" rx="1.5"/></g><g fill="black" fill-opacity="0.4"><rect x="14" y="21" width="14" height="3" rx="1.5"/><rect x="26" y="17" width="4" height="3" rx="1.5"/><rect x="14" y="25" width="8" height="3" rx="1.5"/></g></svg>`
+	copyBufSize         = 256 << 10 // 256KB copy buffer
+	httpClientTimeout   = 15
+	runHistoryLimit     = 5
+	errorAuth           = "error unauthorized"
+	errorScript         = "error script fail"
+	errorAction         = "error invalid action"
+	errorGroup          = "error group invalid"
+	errorRunNotFound    = "error run not found"
+	errorRunNotRunning  = "error run is not running"
+	errorRunNotPending  = "error run is not pending approval"
+	errorQueueCancelled = "error queued run cancelled"
+	errorWorkflow       = "error invalid workflow"
+	defaultDebounce     = 500  // milliseconds
+	defaultTolerance    = 300  // seconds
+	approvalTimeout     = 3600 // seconds
+	oneOffTag           = "one-off"
+	misfireLimit        = 10
+	previewCount        = 5
+	previewLimit        = 100
+	pausedScheduler     = "scheduler"
+	headerRunID         = "X-Pal-Run-Id"
+	headerExitCode      = "X-Pal-Exit-Code"
+	headerQueuePosition = "X-Pal-Queue-Position"
+	headerQueueLength   = "X-Pal-Queue-Length"
+	favicon             = `<svg width="32" height="32" viewBox="0 0 32 32" fill="none" xmlns="http://www.w3.org/2000/svg"><rect width="32" height="32" rx="4" fill="#2D333B"/><g fill="white"><rect x="6" y="5" width="20" height="3" rx="1.5"/><rect x="6" y="9" width="8" height="3" rx="1.5"/><rect x="18" y="9" width="8" height="3" rx="1.5"/><rect x="6" y="13" width="8" height="3" rx="1.5"/><rect x="18" y="13" width="8" height="3" rx="1.5"/><rect x="6" y="17" width="18" height="3" rx="1.5"/><rect x="6" y="21" width="6" height="3" rx="1.5"/><rect x="6" y="25" width="6" height="3" rx="1.5"/></g><g fill="black" fill-opacity="0.4"><rect x="14" y="21" width="14" height="3" rx="1.5"/><rect x="26" y="17" width="4" height="3" rx="1.5"/><rect x="14" y="25" width="8" height="3" rx="1.5"/></g></svg>`
 )
 
+// Elector elects the node running cron schedules when more than one pal shares them, see utils.FileLease
+type Elector interface {
+	// IsLeader returns nil when this node is the leader, like gocron.Elector
+	IsLeader(ctx context.Context) error
+	// Leader returns the node name of the leader, empty if there's none
+	Leader() string
+	// Node returns the node name of this node
+	Node() string
+}
+
 var (
 	sched                     gocron.Scheduler
+	elector                   Elector
+	groupsMu                  sync.Mutex
+	watcher                   *fsnotify.Watcher
+	watchMu                   sync.Mutex
+	watchTimers               = make(map[string]*time.Timer)
+	paramsMu                  sync.Mutex
+	paramSchemas              = make(map[string]*jsonschema.Schema)
+	cronKeysMu                sync.RWMutex
+	cronKeys                  = make(map[uuid.UUID]string)
 	validate                  = validator.New(validator.WithRequiredStructEnabled())
 	DefaultCacheControlConfig = CacheControlConfig{
 		Immutable: true,
@@ -147,12 +197,57 @@ func checkBasicAuth(c *echo.Context) bool {
 	return false
 }
 
-// lock sets the lock for blocking requests until cmd has finished
-func lock(group, action string, lockState bool) {
-	resData := db.DBC.GetGroupAction(group, action)
+// runLimit returns how many runs of the action can be active at once, 1 for non-concurrent and 0 for unlimited
+func runLimit(actionData data.ActionData) int {
+	if !actionData.Concurrent {
+		return 1
+	}
+	return actionData.MaxConcurrent
+}
 
-	resData.Lock = lockState
-	db.DBC.PutGroupAction(group, resData)
+// acquire takes a run slot of an action with a run limit, the returned channel is non-nil when the run is queued
+func acquire(actionData data.ActionData, id string) (<-chan bool, error) {
+	limit := runLimit(actionData)
+	if limit == 0 {
+		return nil, nil
+	}
+	return db.Acquire(actionData.Group+"_"+actionData.Action, id, limit, actionData.Queue)
+}
+
+// release hands the run slot of an action with a run limit to the next queued run or frees it when the queue is empty
+func release(actionData data.ActionData) {
+	if runLimit(actionData) > 0 {
+		db.Release(actionData.Group + "_" + actionData.Action)
+	}
+}
+
+// waitQueue records the run as queued and blocks until a run slot is handed to it,
+// returns false if the queued run was cancelled or done was closed before it started
+func waitQueue(run data.RunRecord, ready <-chan bool, done <-chan struct{}) bool {
+	run.Status = "queued"
+	if err := db.DBC.PutRun(run); err != nil {
+		logError("", "", err)
+	}
+
+	select {
+	case ok := <-ready:
+		if ok {
+			return true
+		}
+	case <-done:
+		// The slot was handed over as the request went away, pass it on to the next run
+		if !db.CancelQueued(run.ID) && <-ready {
+			db.Release(run.Group + "_" + run.Action)
+		}
+	}
+
+	run.Status = "cancelled"
+	run.Ended = utils.TimeNow(config.GetConfigStr("global_timezone"))
+	if err := db.DBC.PutRun(run); err != nil {
+		logError("", "", err)
+	}
+
+	return false
 }
 
 func condDisable(group, action string, disabled bool) {
@@ -162,31 +257,12 @@ func condDisable(group, action string, disabled bool) {
 	db.DBC.PutGroupAction(group, resData)
 	if disabled {
 		sched.RemoveByTags(group + action)
+		removeCronKeys(group, action, uuid.Nil)
 	} else {
-		if len(resData.Schedule) > 0 {
-			for _, c := range resData.Schedule {
-				if validateInput(c, "cron") == nil {
-					var cronDesc string
-					exprDesc, err := cron.NewDescriptor()
-					if err == nil {
-						cronDesc, err = exprDesc.ToDescription(c, cron.Locale_en)
-						if err != nil {
-							cronDesc = ""
-						}
-					}
-
-					_, err = sched.NewJob(
-						gocron.CronJob(c, false),
-						gocron.NewTask(cronTask, resData),
-						gocron.WithName(group+"/"+action),
-						gocron.WithTags(cronDesc, group+action),
-					)
-
-					if err != nil {
-						// TODOD: log error
-						return
-					}
-				}
+		for _, s := range resData.Schedule {
+			if err := scheduleCron(resData, s); err != nil {
+				logError("", "", err)
+				return
 			}
 		}
 		return
@@ -256,20 +332,29 @@ func RunGroup(c *echo.Context) error {
 	}
 
 	auth, authHeader := utils.GetAuthHeader(actionData)
+	signed := actionData.Auth.Type != ""
 
-	// Check if auth header is present and if the header is correct
+	// Check if auth header is present and if the header is correct, then the signature if the action has auth
 	auth_pass := false
-	if auth {
+	if auth || signed {
 		if strings.HasPrefix(c.Request().RequestURI, "/v1/pal/ui") {
 			if !sessionValid(c) && !checkBasicAuth(c) {
 				return c.Redirect(http.StatusSeeOther, "/v1/pal/ui/login")
 			}
 			auth_pass = true
-		}
-		for k, v := range c.Request().Header {
-			header := strings.Join([]string{k, v[0]}, " ")
-			if header == authHeader {
-				auth_pass = true
+		} else {
+			auth_pass = !auth
+			for k, v := range c.Request().Header {
+				header := strings.Join([]string{k, v[0]}, " ")
+				if header == authHeader {
+					auth_pass = true
+				}
+			}
+			if auth_pass && signed {
+				if err := verifyAuth(c, actionData.Auth); err != nil {
+					logError(c.Response().Header().Get(echo.HeaderXRequestID), c.Request().RequestURI, err)
+					auth_pass = false
+				}
 			}
 		}
 
@@ -305,14 +390,13 @@ func RunGroup(c *echo.Context) error {
 		return c.String(http.StatusBadRequest, "error action is disabled")
 	}
 
-	if auth {
-		if !isAdminExec(c, authHeader) {
+	// A verified signature allows the run like a matching auth header
+	if auth || signed {
+		if !isAdminExec(c, cmp.Or(authHeader, actionData.Auth.Type)) {
 			return c.String(http.StatusForbidden, "error role is not admin or execute")
 		}
 	}
 
-	cmdOrig := actionData.Cmd
-
 	var input string
 
 	if c.Request().Method == http.MethodPost {
@@ -323,6 +407,14 @@ func RunGroup(c *echo.Context) error {
 		input = string(bodyBytes)
 	} else {
 		input = c.QueryParam("input")
+		// Params can be set by name in query params instead of a JSON object input
+		if input == "" && len(actionData.Params) > 0 {
+			var err error
+			input, err = paramsQuery(actionData.Params, c.QueryParams())
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, "error with params: "+err.Error())
+			}
+		}
 	}
 
 	if input == "" {
@@ -336,244 +428,164 @@ func RunGroup(c *echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "error with input validation: "+err.Error())
 	}
 
+	if _, err := actionParams(actionData, input); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "error with params: "+err.Error())
+	}
+
+	// Schedule a single run at a time or after a delay instead of running now
+	if c.QueryParam("at") != "" || c.QueryParam("delay") != "" {
+		at, err := runAt(c.QueryParam("at"), c.QueryParam("delay"))
+		if err != nil {
+			return c.String(http.StatusBadRequest, err.Error())
+		}
+
+		id, err := uuid.NewV7()
+		if err != nil {
+			id = uuid.New()
+		}
+
+		oneOff := data.OneOff{
+			ID:      id.String(),
+			Group:   actionData.Group,
+			Action:  actionData.Action,
+			Input:   input,
+			At:      at,
+			Created: utils.TimeNow(config.GetConfigStr("global_timezone")),
+		}
+		oneOff.CreatedBy, _ = currentUser(c)
+
+		if err := db.DBC.PutOneOff(oneOff); err != nil {
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+		if err := scheduleOneOff(oneOff); err != nil {
+			_ = db.DBC.DeleteOneOff(oneOff.ID)
+			return c.String(http.StatusInternalServerError, err.Error())
+		}
+
+		return c.JSON(http.StatusAccepted, oneOff)
+	}
+
+	// Blocked windows are checked when the run starts, a one-off above is checked when it's due
+	if err := checkWindows(actionData, time.Now()); err != nil {
+		return c.String(http.StatusLocked, err.Error())
+	}
+
 	req, err := requestJSON(c, input)
 	if err != nil {
 		req = ""
 	}
 
-	actionData.Cmd = cmdString(actionData, input, req)
-
-	// Check if action wants to block the request to one
-	if !actionData.Concurrent {
-		if actionData.Lock {
-			return c.String(http.StatusTooManyRequests, errorNotReady)
+	// Stream cmd output as it's written using Server-Sent Events
+	streaming := c.QueryParam("stream") == "true"
+	if streaming {
+		if !actionData.Output {
+			return c.String(http.StatusBadRequest, "error output not enabled")
 		}
+		if actionData.Background {
+			return c.String(http.StatusBadRequest, "error stream not supported for background actions")
+		}
+	}
 
-		lock(group, action, true)
+	trigger := "http"
+	if strings.HasPrefix(c.Request().RequestURI, "/v1/pal/ui") {
+		trigger = "ui"
 	}
 
-	if actionData.Background {
+	run := newRun(actionData, trigger, input)
+
+	// Runs that need approval respond right away as pending and run in the background once approved
+	if actionData.Approval.Required {
+		run.RequestedBy, _ = currentUser(c)
+		run, _ = requestApproval(actionData, run)
 		go func() {
-			db.PutRunning(group + "_" + action)
-			cmdOutput, duration, err := utils.CmdRun(actionData, config.GetConfigStr("global_cmd_prefix"), config.GetConfigStr("global_working_dir"))
-			db.DeleteRunning(group + "_" + action)
-			actionData.Cmd = cmdOrig
+			_, err := runAction(actionData, run, input, req, nil)
 			if err != nil {
-				if !actionData.Concurrent {
-					lock(group, action, false)
-				}
-				actionData.Status = "error"
-				actionData.RunCount++
-				actionData.LastDuration = duration
-				actionData.LastRan = utils.TimeNow(config.GetConfigStr("global_timezone"))
-				actionData.LastFailure = actionData.LastRan
-				if actionData.Output {
-					actionData.LastFailureOutput = err.Error() + " " + cmdOutput
-				}
-				mergeGroup(actionData)
-				registerActionDB(actionData, actionData.LastFailureOutput, input)
 				logError("", "", err)
-				if actionData.OnError.Notification != "" {
-					notification := actionData.OnError.Notification
-					notification = strings.ReplaceAll(notification, "$PAL_GROUP", actionData.Group)
-					notification = strings.ReplaceAll(notification, "$PAL_ACTION", actionData.Action)
-					notification = strings.ReplaceAll(notification, "$PAL_INPUT", input)
-					notification = strings.ReplaceAll(notification, "$PAL_STATUS", actionData.Status)
-					if actionData.Output {
-						notification = strings.ReplaceAll(notification, "$PAL_OUTPUT", actionData.LastFailureOutput)
-					}
-					err := putNotifications(data.Notification{Group: group, Action: action, Status: actionData.Status, Notification: notification})
-					if err != nil {
-						logError("", "", err)
-					}
-				}
-				sendWebhookNotifications(actionData, actionData.LastFailureOutput, input)
-				for _, e := range actionData.OnError.Run {
-					runAction := db.DBC.GetGroupAction(e.Group, e.Action)
-					if !runAction.Disabled {
-						go func() {
-							errorGroup := e.Group
-							errorAction := e.Action
-							errorInput := e.Input
-							errorInput = strings.ReplaceAll(errorInput, "$PAL_GROUP", actionData.Group)
-							errorInput = strings.ReplaceAll(errorInput, "$PAL_ACTION", actionData.Action)
-							if actionData.Output {
-								errorInput = strings.ReplaceAll(errorInput, "$PAL_OUTPUT", actionData.LastFailureOutput)
-							}
-							errorInput = strings.ReplaceAll(errorInput, "$PAL_STATUS", actionData.Status)
-
-							runBackground(errorGroup, errorAction, errorInput)
-						}()
-					}
-				}
-				return
 			}
-			actionData.Status = "success"
-			actionData.RunCount++
-			actionData.LastDuration = duration
-			actionData.LastRan = utils.TimeNow(config.GetConfigStr("global_timezone"))
-			actionData.LastSuccess = actionData.LastRan
-			if actionData.Output {
-				actionData.LastSuccessOutput = cmdOutput
-			}
-			mergeGroup(actionData)
-			registerActionDB(actionData, actionData.LastSuccessOutput, input)
-			if actionData.OnSuccess.Notification != "" {
-				notification := actionData.OnSuccess.Notification
-				notification = strings.ReplaceAll(notification, "$PAL_GROUP", actionData.Group)
-				notification = strings.ReplaceAll(notification, "$PAL_ACTION", actionData.Action)
-				notification = strings.ReplaceAll(notification, "$PAL_INPUT", input)
-				notification = strings.ReplaceAll(notification, "$PAL_STATUS", actionData.Status)
-				if actionData.Output {
-					notification = strings.ReplaceAll(notification, "$PAL_OUTPUT", cmdOutput)
-				}
-				err := putNotifications(data.Notification{Group: group, Action: action, Status: actionData.Status, Notification: notification})
-				if err != nil {
-					logError("", "", err)
-				}
+		}()
+
+		c.Response().Header().Set(headerRunID, run.ID)
+		return c.String(http.StatusAccepted, "pending approval")
+	}
+
+	// Check if action limits concurrent runs, queue the request if the action has a queue
+	ready, err := acquire(actionData, run.ID)
+	if err != nil {
+		return c.String(http.StatusTooManyRequests, err.Error())
+	}
+
+	c.Response().Header().Set(headerRunID, run.ID)
+
+	if ready != nil {
+		position, length := db.GetQueuePosition(run.ID)
+		c.Response().Header().Set(headerQueuePosition, strconv.Itoa(position))
+		c.Response().Header().Set(headerQueueLength, strconv.Itoa(length))
+	}
+
+	if actionData.Background {
+		go func() {
+			if ready != nil && !waitQueue(run, ready, nil) {
+				return
 			}
-			sendWebhookNotifications(actionData, actionData.LastSuccessOutput, input)
-			for _, e := range actionData.OnSuccess.Run {
-				runAction := db.DBC.GetGroupAction(e.Group, e.Action)
-				if !runAction.Disabled {
-					go func() {
-						successGroup := e.Group
-						successAction := e.Action
-						successInput := e.Input
-						successInput = strings.ReplaceAll(successInput, "$PAL_GROUP", actionData.Group)
-						successInput = strings.ReplaceAll(successInput, "$PAL_ACTION", actionData.Action)
-						successInput = strings.ReplaceAll(successInput, "$PAL_STATUS", actionData.Status)
-						if actionData.Output {
-							successInput = strings.ReplaceAll(successInput, "$PAL_OUTPUT", cmdOutput)
-						}
-						runBackground(successGroup, successAction, successInput)
-					}()
-				}
+			_, err := execAction(actionData, run, input, req, nil, nil)
+			release(actionData)
+			if err != nil {
+				logError("", "", err)
 			}
 		}()
 
-		if !actionData.Concurrent {
-			lock(group, action, false)
+		if ready != nil {
+			return c.String(http.StatusAccepted, "queued in background")
 		}
 
 		return c.String(http.StatusOK, "running in background")
 	}
 
-	db.PutRunning(group + "_" + action)
-	cmdOutput, duration, err := utils.CmdRun(actionData, config.GetConfigStr("global_cmd_prefix"), config.GetConfigStr("global_working_dir"))
-	db.DeleteRunning(group + "_" + action)
-	actionData.Cmd = cmdOrig
-	if err != nil {
-		if !actionData.Concurrent {
-			lock(group, action, false)
-		}
-		actionData.Status = "error"
-		actionData.RunCount++
-		actionData.LastDuration = duration
-		actionData.LastRan = utils.TimeNow(config.GetConfigStr("global_timezone"))
-		actionData.LastFailure = actionData.LastRan
-		if actionData.Output {
-			actionData.LastFailureOutput = err.Error() + " " + cmdOutput
+	var stream *sseStream
+	var stdout, stderr io.Writer
+	if streaming {
+		stream = newSSEStream(c)
+		stdout, stderr = stream.stdout, stream.stderr
+	}
+
+	if ready != nil {
+		if stream != nil {
+			stream.send("queued", fmt.Sprintf("queued at position %s of %s", c.Response().Header().Get(headerQueuePosition), c.Response().Header().Get(headerQueueLength)))
 		}
-		mergeGroup(actionData)
-		registerActionDB(actionData, actionData.LastFailureOutput, input)
-		logError(c.Response().Header().Get(echo.HeaderXRequestID), c.Request().RequestURI, errors.New(errorScript+" "+err.Error()))
-		if actionData.OnError.Notification != "" {
-			notification := actionData.OnError.Notification
-			notification = strings.ReplaceAll(notification, "$PAL_GROUP", actionData.Group)
-			notification = strings.ReplaceAll(notification, "$PAL_ACTION", actionData.Action)
-			notification = strings.ReplaceAll(notification, "$PAL_INPUT", input)
-			notification = strings.ReplaceAll(notification, "$PAL_STATUS", actionData.Status)
-			if actionData.Output {
-				notification = strings.ReplaceAll(notification, "$PAL_OUTPUT", actionData.LastFailureOutput)
-			}
-			err := putNotifications(data.Notification{Group: group, Action: action, Status: actionData.Status, Notification: notification})
-			if err != nil {
-				logError(c.Response().Header().Get(echo.HeaderXRequestID), c.Request().RequestURI, err)
-			}
-		}
-		sendWebhookNotifications(actionData, actionData.LastFailureOutput, input)
-		for _, e := range actionData.OnError.Run {
-			runAction := db.DBC.GetGroupAction(e.Group, e.Action)
-			if !runAction.Disabled {
-				go func() {
-					errorGroup := e.Group
-					errorAction := e.Action
-					errorInput := e.Input
-					errorInput = strings.ReplaceAll(errorInput, "$PAL_GROUP", actionData.Group)
-					errorInput = strings.ReplaceAll(errorInput, "$PAL_ACTION", actionData.Action)
-					errorInput = strings.ReplaceAll(errorInput, "$PAL_STATUS", actionData.Status)
-					if actionData.Output {
-						errorInput = strings.ReplaceAll(errorInput, "$PAL_OUTPUT", actionData.LastFailureOutput)
-					}
-					runBackground(errorGroup, errorAction, errorInput)
-				}()
+		if !waitQueue(run, ready, c.Request().Context().Done()) {
+			if stream != nil {
+				stream.close("cancelled", errorQueueCancelled, run.ExitCode)
+				return nil
 			}
+			return c.String(http.StatusConflict, errorQueueCancelled)
 		}
-		return c.String(http.StatusInternalServerError, err.Error())
 	}
 
-	// Unlock if blocking is enabled
-	if !actionData.Concurrent {
-		lock(group, action, false)
+	run, err = execAction(actionData, run, input, req, stdout, stderr)
+	if stream == nil {
+		c.Response().Header().Set(headerExitCode, strconv.Itoa(run.ExitCode))
 	}
 
-	if actionData.Output {
-		actionData.Status = "success"
-		actionData.RunCount++
-		actionData.LastDuration = duration
-		actionData.LastRan = utils.TimeNow(config.GetConfigStr("global_timezone"))
-		actionData.LastSuccess = actionData.LastRan
-		if actionData.Output {
-			actionData.LastSuccessOutput = cmdOutput
-		}
-		mergeGroup(actionData)
-		registerActionDB(actionData, actionData.LastSuccessOutput, input)
-		if actionData.OnSuccess.Notification != "" {
-			notification := actionData.OnSuccess.Notification
-			notification = strings.ReplaceAll(notification, "$PAL_GROUP", actionData.Group)
-			notification = strings.ReplaceAll(notification, "$PAL_ACTION", actionData.Action)
-			notification = strings.ReplaceAll(notification, "$PAL_INPUT", input)
-			notification = strings.ReplaceAll(notification, "$PAL_STATUS", actionData.Status)
-			if actionData.Output {
-				notification = strings.ReplaceAll(notification, "$PAL_OUTPUT", cmdOutput)
-			}
-			err := putNotifications(data.Notification{Group: group, Action: action, Status: actionData.Status, Notification: notification})
-			if err != nil {
-				logError("", "", err)
-			}
-		}
-		sendWebhookNotifications(actionData, actionData.LastSuccessOutput, input)
-		for _, e := range actionData.OnSuccess.Run {
-			runAction := db.DBC.GetGroupAction(e.Group, e.Action)
-			if !runAction.Disabled {
-				go func() {
-					successGroup := e.Group
-					successAction := e.Action
-					successInput := e.Input
-					successInput = strings.ReplaceAll(successInput, "$PAL_GROUP", actionData.Group)
-					successInput = strings.ReplaceAll(successInput, "$PAL_ACTION", actionData.Action)
-					successInput = strings.ReplaceAll(successInput, "$PAL_STATUS", actionData.Status)
-					if actionData.Output {
-						successInput = strings.ReplaceAll(successInput, "$PAL_OUTPUT", cmdOutput)
-					}
-					runBackground(successGroup, successAction, successInput)
-				}()
-			}
+	release(actionData)
+
+	if err != nil {
+		logError(c.Response().Header().Get(echo.HeaderXRequestID), c.Request().RequestURI, errors.New(errorScript+" "+err.Error()))
+		if stream != nil {
+			stream.close(run.Status, err.Error(), run.ExitCode)
+			return nil
 		}
-		return c.String(http.StatusOK, cmdOutput)
+		return c.String(http.StatusInternalServerError, err.Error())
+	}
+
+	if stream != nil {
+		stream.close("success", "", run.ExitCode)
+		return nil
 	}
 
-	actionData.Status = "success"
-	actionData.RunCount++
-	actionData.LastDuration = duration
-	actionData.LastRan = utils.TimeNow(config.GetConfigStr("global_timezone"))
-	actionData.LastSuccess = actionData.LastRan
 	if actionData.Output {
-		actionData.LastSuccessOutput = cmdOutput
+		return c.String(http.StatusOK, run.Stdout)
 	}
-	mergeGroup(actionData)
-	registerActionDB(actionData, actionData.LastSuccessOutput, input)
+
 	return c.String(http.StatusOK, "done")
 }
 
@@ -714,12 +726,18 @@ func GetSchedulesJSON(c *echo.Context) error {
 	scheds := []data.Schedule{}
 
 	actionData := db.DBC.GetGroupAction(group, action)
+	paused := db.DBC.GetPaused()
+	schedules := cronSchedules(db.DBC.GetGroups())
 
 	for _, e := range sched.Jobs() {
-		if name == e.Name() && c.QueryParam("run") == "now" {
+		oneOff := slices.Contains(e.Tags(), oneOffTag)
+		if name == e.Name() && c.QueryParam("run") == "now" && !oneOff {
 			if !isAdminExec(c, actionData.AuthHeader) {
 				return c.String(http.StatusForbidden, "error role is not admin or execute")
 			}
+			if !isLeader() {
+				return c.JSON(http.StatusConflict, data.GenericResponse{Err: "error node is standby, schedules run on the leader " + elector.Leader()})
+			}
 			err := e.RunNow()
 			if err != nil {
 				return c.JSON(http.StatusInternalServerError, data.GenericResponse{Err: err.Error()})
@@ -732,14 +750,27 @@ func GetSchedulesJSON(c *echo.Context) error {
 		action := strings.Split(e.Name(), "/")[1]
 		lastRan, _ := time.Parse(time.RFC3339, actionData.LastRan)
 
-		scheds = append(scheds, data.Schedule{
+		schedule := data.Schedule{
 			Group:        group,
 			Action:       action,
 			NextRun:      nextrun,
 			LastRan:      lastRan,
 			LastDuration: actionData.LastDuration,
 			Status:       actionData.Status,
-		})
+			OneOff:       oneOff,
+		}
+		schedule.ID = e.ID().String()
+		if oneOff {
+			if o, err := db.DBC.GetOneOff(schedule.ID); err == nil {
+				schedule.Input = o.Input
+			}
+		} else if s, ok := schedules[e.ID()]; ok {
+			schedule.Cron = s.Cron
+			schedule.Input = s.Input
+			schedule.Paused = pausedBy(paused, group, scheduleKey(group, action, s)) != ""
+		}
+
+		scheds = append(scheds, schedule)
 	}
 
 	return c.JSON(http.StatusOK, scheds)
@@ -751,22 +782,33 @@ func GetSchedules(c *echo.Context) error {
 	}
 
 	type schedules struct {
-		RunHistory   []data.RunHistory
-		ScheduleDesc string
-		Group        string
-		Action       string
-		NextRun      string
-		LastRan      string
-		LastDuration string
+		RunHistory []data.RunHistory
+		ID         string
+		OneOff     bool
+		Paused     bool
+		// PausedSchedule is set when the schedule itself is paused, not only its group or the scheduler
+		PausedSchedule bool
+		ScheduleDesc   string
+		Group          string
+		Action         string
+		NextRun        string
+		LastRan        string
+		LastDuration   string
 	}
 
 	scheds := []schedules{}
+	paused := db.DBC.GetPaused()
+	groups := db.DBC.GetGroups()
 
 	for _, e := range sched.Jobs() {
 		nextrun, _ := e.NextRun()
 		group := strings.Split(e.Name(), "/")[0]
 		action := strings.Split(e.Name(), "/")[1]
-		actionData := db.DBC.GetGroupAction(group, action)
+		var actionData data.ActionData
+		if i := slices.IndexFunc(groups[group], func(a data.ActionData) bool { return a.Action == action }); i >= 0 {
+			actionData = groups[group][i]
+			actionData.RunHistory = slices.Clone(actionData.RunHistory)
+		}
 		parsedTime, err := time.Parse(time.RFC3339, actionData.LastRan)
 		if err == nil {
 			actionData.LastRan = humanize.Time(parsedTime)
@@ -779,22 +821,31 @@ func GetSchedules(c *echo.Context) error {
 			}
 		}
 
+		// Pauses are stored by the schedule key of the cron job
+		key := cronKey(e.ID())
+
 		scheds = append(scheds, schedules{
-			Group:        strings.Split(e.Name(), "/")[0],
-			Action:       strings.Split(e.Name(), "/")[1],
-			NextRun:      humanize.Time(nextrun),
-			RunHistory:   actionData.RunHistory,
-			LastRan:      actionData.LastRan,
-			LastDuration: actionData.LastDuration,
-			ScheduleDesc: e.Tags()[0],
+			Group:          strings.Split(e.Name(), "/")[0],
+			Action:         strings.Split(e.Name(), "/")[1],
+			NextRun:        humanize.Time(nextrun),
+			RunHistory:     actionData.RunHistory,
+			LastRan:        actionData.LastRan,
+			LastDuration:   actionData.LastDuration,
+			ScheduleDesc:   e.Tags()[0],
+			ID:             e.ID().String(),
+			OneOff:         slices.Contains(e.Tags(), oneOffTag),
+			Paused:         pausedBy(paused, group, key) != "",
+			PausedSchedule: paused["schedule/"+key],
 		})
 	}
 
 	uiData := struct {
 		Schedules     []schedules
+		Paused        bool
 		Notifications int
 	}{
 		Schedules:     scheds,
+		Paused:        paused[pausedScheduler],
 		Notifications: len(db.DBC.GetNotifications("", "")),
 	}
 
@@ -923,26 +974,13 @@ func PostDBput(c *echo.Context) error {
 	return c.Redirect(http.StatusFound, "/v1/pal/ui/db")
 }
 
-func registerActionDB(actionData data.ActionData, output, input string) {
-	key := actionData.Register.Key
-	if key == "" {
+func registerActionDB(actionData data.ActionData, run data.RunRecord) {
+	if actionData.Register.Key == "" {
 		return
 	}
-	key = strings.ReplaceAll(key, "$PAL_GROUP", actionData.Group)
-	key = strings.ReplaceAll(key, "$PAL_ACTION", actionData.Action)
-	key = strings.ReplaceAll(key, "$PAL_INPUT", input)
-	key = strings.ReplaceAll(key, "$PAL_STATUS", actionData.Status)
-	if actionData.Output {
-		key = strings.ReplaceAll(key, "$PAL_OUTPUT", output)
-	}
-	value := actionData.Register.Value
-	value = strings.ReplaceAll(value, "$PAL_GROUP", actionData.Group)
-	value = strings.ReplaceAll(value, "$PAL_ACTION", actionData.Action)
-	value = strings.ReplaceAll(value, "$PAL_INPUT", input)
-	value = strings.ReplaceAll(value, "$PAL_STATUS", actionData.Status)
-	if actionData.Output {
-		value = strings.ReplaceAll(value, "$PAL_OUTPUT", output)
-	}
+	templateVars := newTemplateData(actionData, run)
+	key := renderTemplate(actionData.Register.Key, templateVars, nil)
+	value := renderTemplate(actionData.Register.Value, templateVars, nil)
 
 	dbSet := data.DBSet{
 		Key:    key,
@@ -1218,6 +1256,15 @@ func GetSystemPage(c *echo.Context) error {
 	uiData.Configs["http_headers"] = fmt.Sprint(config.GetConfigResponseHeaders())
 	uiData.Configs["http_users"] = fmt.Sprint(usersData.Users)
 	uiData.Configs["notifications_store_max"] = strconv.Itoa(config.GetConfigInt("notifications_store_max"))
+	uiData.Configs["leader_node"] = config.GetConfigStr("leader_node")
+	if elector == nil {
+		uiData.Configs["leader"] = "disabled, every node runs schedules"
+	} else {
+		uiData.Configs["leader"] = cmp.Or(elector.Leader(), "none")
+		if isLeader() {
+			uiData.Configs["leader"] += " (this node)"
+		}
+	}
 
 	uiData.Notifications = len(db.DBC.GetNotifications("", ""))
 
@@ -1231,7 +1278,12 @@ func GetActions(c *echo.Context) error {
 	res := db.DBC.GetGroups()
 	var actionsSlice []data.ActionData
 	for _, actions := range res {
-		actionsSlice = append(actionsSlice, actions...)
+		for _, e := range actions {
+			if e.Auth.Secret != "" {
+				e.Auth.Secret = "hidden"
+			}
+			actionsSlice = append(actionsSlice, e)
+		}
 	}
 
 	return c.JSON(http.StatusOK, actionsSlice)
@@ -1375,9 +1427,12 @@ func GetActionPage(c *echo.Context) error {
 
 	uiData := struct {
 		ActionMap     map[string]data.ActionData
+		Pending       []data.RunRecord
 		Notifications int
 	}{}
 
+	uiData.Pending = db.DBC.GetRuns(group, action, "", "pending", time.Time{})
+
 	for runIndex, run := range res.RunHistory {
 		parsedTime, err := time.Parse(time.RFC3339, run.Ran)
 		if err == nil {
@@ -1458,6 +1513,12 @@ func GetAction(c *echo.Context) error {
 
 	if resMap.Action == action {
 		resMap.AuthHeader = "hidden"
+		if resMap.Auth.Secret != "" {
+			resMap.Auth.Secret = "hidden"
+		}
+		if len(resMap.Params) > 0 {
+			resMap.Schema, _ = utils.ParamsSchema(resMap.Params)
+		}
 		if yaml == "true" {
 			return Yaml(c, resMap)
 		}
@@ -1668,7 +1729,34 @@ func GetReloadActions(c *echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "error reloading actions "+err.Error())
 	}
+	config.SetWorkflows(config.ReadWorkflows(config.GetConfigStr("global_actions_dir"), groups))
+	changes, err := syncSchedules(db.DBC.GetGroups())
+	if err != nil {
+		// Report the schedules already changed when the scheduler failed part way through
+		changes.Err = "error reloading schedules " + err.Error()
+		if !strings.HasPrefix(c.Request().RequestURI, "/v1/pal/ui") {
+			return c.JSON(http.StatusInternalServerError, changes)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, changes.Err)
+	}
+	err = WatchStart(db.DBC.GetGroups())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "error reloading watches "+err.Error())
+	}
 	config.SetActionsReload()
+
+	if !strings.HasPrefix(c.Request().RequestURI, "/v1/pal/ui") {
+		return c.JSON(http.StatusOK, changes)
+	}
+
+	if n := len(changes.Added) + len(changes.Removed) + len(changes.Updated); n > 0 {
+		notification := fmt.Sprintf("reloaded actions, schedules added %d removed %d updated %d",
+			len(changes.Added), len(changes.Removed), len(changes.Updated))
+		if err := putNotifications(data.Notification{Group: "pal", Status: "reload", Notification: notification}); err != nil {
+			logError("", "", err)
+		}
+	}
+
 	return c.Redirect(http.StatusTemporaryRedirect, "/v1/pal/ui/system")
 }
 
@@ -1729,6 +1817,107 @@ func sessionValid(c *echo.Context) bool {
 	return val
 }
 
+// verifyAuth checks the signature or token header of a request against the secret of auth, the body is
+// put back for reading the input after
+func verifyAuth(c *echo.Context, auth data.Auth) error {
+	secret := auth.Secret
+	if auth.SecretKey != "" {
+		dbSet, err := db.DBC.Get(auth.SecretKey)
+		if err != nil {
+			return fmt.Errorf("error auth secret_key %s: %w", auth.SecretKey, err)
+		}
+		secret = dbSet.Value
+	}
+	if secret == "" {
+		return errors.New("error auth secret is empty")
+	}
+
+	value := c.Request().Header.Get(auth.Header)
+	if value == "" {
+		return errors.New("error auth header " + auth.Header + " missing")
+	}
+
+	if auth.Type == "token" {
+		if subtle.ConstantTimeCompare([]byte(value), []byte(auth.Prefix+secret)) != 1 {
+			return errors.New("error auth token mismatch")
+		}
+		return nil
+	}
+
+	var timestamp string
+	if auth.TimestampHeader != "" {
+		fields := headerFields(c.Request().Header.Get(auth.TimestampHeader), auth.TimestampPrefix)
+		if len(fields) == 0 {
+			return errors.New("error auth header " + auth.TimestampHeader + " missing")
+		}
+		timestamp = fields[0]
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return errors.New("error auth timestamp invalid " + timestamp)
+		}
+		tolerance := cmp.Or(auth.Tolerance, defaultTolerance)
+		if time.Since(time.Unix(ts, 0)).Abs() > time.Duration(tolerance)*time.Second {
+			return fmt.Errorf("error auth timestamp %s outside tolerance of %ds", timestamp, tolerance)
+		}
+	}
+
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return fmt.Errorf("error auth reading body: %w", err)
+	}
+	c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+	payload := body
+	if auth.Payload != "" {
+		tmpl, err := txttemplate.New("payload").Parse(auth.Payload)
+		if err != nil {
+			return fmt.Errorf("error auth payload: %w", err)
+		}
+		var buf bytes.Buffer
+		err = tmpl.Execute(&buf, struct{ Timestamp, Body string }{timestamp, string(body)})
+		if err != nil {
+			return fmt.Errorf("error auth payload: %w", err)
+		}
+		payload = buf.Bytes()
+	}
+
+	hash := sha256.New
+	if auth.Type == "hmac-sha1" {
+		hash = sha1.New
+	}
+	mac := hmac.New(hash, []byte(secret))
+	mac.Write(payload)
+	sum := mac.Sum(nil)
+
+	for _, field := range headerFields(value, auth.Prefix) {
+		var sig []byte
+		if auth.Encoding == "base64" {
+			sig, err = base64.StdEncoding.DecodeString(field)
+		} else {
+			sig, err = hex.DecodeString(field)
+		}
+		if err == nil && hmac.Equal(sig, sum) {
+			return nil
+		}
+	}
+
+	return errors.New("error auth signature mismatch")
+}
+
+// headerFields returns the comma separated fields of a header value starting with prefix, without the prefix,
+// e.g. Stripe-Signature: t=1700000000,v1=5257a8...
+func headerFields(value, prefix string) []string {
+	var fields []string
+	for field := range strings.SplitSeq(value, ",") {
+		field = strings.TrimSpace(field)
+		if field != "" && strings.HasPrefix(field, prefix) {
+			fields = append(fields, strings.TrimPrefix(field, prefix))
+		}
+	}
+
+	return fields
+}
+
 func isAdminExec(c *echo.Context, authHeader string) bool {
 	sess, err := session.Get("session", c)
 	if err != nil {
@@ -1745,6 +1934,36 @@ func isAdminExec(c *echo.Context, authHeader string) bool {
 	return role == "admin" || role == "execute"
 }
 
+// currentUser returns the user and role of the session or basic auth of a request
+func currentUser(c *echo.Context) (string, string) {
+	sess, err := session.Get("session", c)
+	if err == nil {
+		if user, ok := sess.Values["username"].(string); ok && user != "" {
+			role, _ := sess.Values["role"].(string)
+			return user, role
+		}
+	}
+
+	if username, password, ok := c.Request().BasicAuth(); ok {
+		for _, user := range config.GetConfigUsers() {
+			if user.User == username && user.Pass == password {
+				return user.User, user.Role
+			}
+		}
+	}
+
+	return "", ""
+}
+
+// canApprove checks the user or their role is an approver, only admins can approve when approvers is empty
+func canApprove(approval data.Approval, user, role string) bool {
+	if len(approval.Approvers) == 0 {
+		return role == "admin"
+	}
+
+	return slices.Contains(approval.Approvers, user) || (role != "" && slices.Contains(approval.Approvers, role))
+}
+
 func isAdmin(c *echo.Context) bool {
 	sess, err := session.Get("session", c)
 	if err != nil {
@@ -1759,441 +1978,2284 @@ func isAdmin(c *echo.Context) bool {
 	return role == "admin"
 }
 
-func cronTask(res data.ActionData) string {
-	actionsData := db.DBC.GetGroupAction(res.Group, res.Action)
-
-	if actionsData.Disabled {
-		return "error action disabled"
-	}
-
-	cmdOrig := actionsData.Cmd
-	actionsData.Cmd = cmdString(actionsData, "", "")
-	timeNow := utils.TimeNow(config.GetConfigStr("global_timezone"))
-	db.PutRunning(res.Group + "_" + res.Action)
-	cmdOutput, duration, err := utils.CmdRun(res, config.GetConfigStr("global_cmd_prefix"), config.GetConfigStr("global_working_dir"))
-	db.DeleteRunning(res.Group + "_" + res.Action)
-	actionsData.Cmd = cmdOrig
-	if err != nil {
-		actionsData.Status = "error"
-		actionsData.RunCount++
-		actionsData.LastDuration = duration
-		actionsData.LastRan = timeNow
-		actionsData.LastFailure = timeNow
-		if actionsData.Output {
-			actionsData.LastFailureOutput = err.Error() + " " + cmdOutput
-		}
-		registerActionDB(actionsData, actionsData.LastFailureOutput, "")
-		mergeGroup(res)
-		logError("", "", err)
-		if actionsData.OnError.Notification != "" {
-			notification := actionsData.OnError.Notification
-			notification = strings.ReplaceAll(notification, "$PAL_GROUP", actionsData.Group)
-			notification = strings.ReplaceAll(notification, "$PAL_ACTION", actionsData.Action)
-			notification = strings.ReplaceAll(notification, "$PAL_INPUT", actionsData.Input)
-			notification = strings.ReplaceAll(notification, "$PAL_STATUS", actionsData.Status)
-			if actionsData.Output {
-				notification = strings.ReplaceAll(notification, "$PAL_OUTPUT", actionsData.LastFailureOutput)
-			}
-			err := putNotifications(data.Notification{Group: actionsData.Group, Action: actionsData.Action, Status: actionsData.Status, Notification: notification})
-			if err != nil {
-				logError("", "", err)
-			}
-		}
-		sendWebhookNotifications(actionsData, actionsData.LastFailureOutput, "")
-		for _, e := range actionsData.OnError.Run {
-			runAction := db.DBC.GetGroupAction(e.Group, e.Action)
-			if !runAction.Disabled {
-				go func() {
-					errorGroup := e.Group
-					errorAction := e.Action
-					errorInput := e.Input
-					errorInput = strings.ReplaceAll(errorInput, "$PAL_GROUP", actionsData.Group)
-					errorInput = strings.ReplaceAll(errorInput, "$PAL_ACTION", actionsData.Action)
-					if actionsData.Output {
-						errorInput = strings.ReplaceAll(errorInput, "$PAL_OUTPUT", actionsData.LastFailureOutput)
-					}
-					errorInput = strings.ReplaceAll(errorInput, "$PAL_STATUS", actionsData.Status)
-					runBackground(errorGroup, errorAction, errorInput)
-				}()
-			}
-		}
-		return err.Error()
+// scheduleCron adds a cron job for a schedule of an action, invalid crons are skipped
+func scheduleCron(actionData data.ActionData, s data.CronSchedule) error {
+	if validateInput(s.Cron, "cron") != nil {
+		return nil
 	}
 
-	// TODO: Log here
-	// fmt.Printf("%s\n", fmt.Sprintf(`{"time":"%s","group":"%s","job_success":%t}`, timeNow, actionsData.Group+"/"+actionsData.Action, true))
-
-	actionsData.Status = "success"
-	actionsData.RunCount++
-	actionsData.LastDuration = duration
-	actionsData.LastRan = timeNow
-	actionsData.LastSuccess = timeNow
-	if actionsData.Output {
-		actionsData.LastSuccessOutput = cmdOutput
-	}
-	registerActionDB(actionsData, actionsData.LastFailureOutput, "")
-	mergeGroup(actionsData)
-	if actionsData.OnSuccess.Notification != "" {
-		notification := actionsData.OnSuccess.Notification
-		notification = strings.ReplaceAll(notification, "$PAL_GROUP", actionsData.Group)
-		notification = strings.ReplaceAll(notification, "$PAL_ACTION", actionsData.Action)
-		notification = strings.ReplaceAll(notification, "$PAL_INPUT", actionsData.Input)
-		notification = strings.ReplaceAll(notification, "$PAL_STATUS", actionsData.Status)
-		if actionsData.Output {
-			notification = strings.ReplaceAll(notification, "$PAL_OUTPUT", cmdOutput)
-		}
-		err := putNotifications(data.Notification{Group: actionsData.Group, Action: actionsData.Action, Status: actionsData.Status, Notification: notification})
+	var cronDesc string
+	exprDesc, err := cron.NewDescriptor()
+	if err == nil {
+		cronDesc, err = exprDesc.ToDescription(s.Cron, cron.Locale_en)
 		if err != nil {
-			logError("", "", err)
+			cronDesc = ""
 		}
 	}
-	sendWebhookNotifications(actionsData, actionsData.LastSuccessOutput, "")
-	for _, e := range actionsData.OnSuccess.Run {
-		runAction := db.DBC.GetGroupAction(e.Group, e.Action)
-		if !runAction.Disabled {
-			go func() {
-				successGroup := e.Group
-				successAction := e.Action
-				successInput := e.Input
-				successInput = strings.ReplaceAll(successInput, "$PAL_GROUP", actionsData.Group)
-				successInput = strings.ReplaceAll(successInput, "$PAL_ACTION", actionsData.Action)
-				successInput = strings.ReplaceAll(successInput, "$PAL_STATUS", actionsData.Status)
-				if actionsData.Output {
-					successInput = strings.ReplaceAll(successInput, "$PAL_OUTPUT", cmdOutput)
-				}
-				runBackground(successGroup, successAction, successInput)
-			}()
-		}
+
+	if s.Timezone != "" {
+		cronDesc = strings.TrimSpace(cronDesc + " " + s.Timezone)
 	}
-	return cmdOutput
-}
 
-func ScheduleStart(r map[string][]data.ActionData) error {
-	loc, err := time.LoadLocation(config.GetConfigStr("global_timezone"))
-	if err != nil {
-		return err
+	id := cronJobID(actionData.Group, actionData.Action, s)
+	options := []gocron.JobOption{
+		gocron.WithName(actionData.Group + "/" + actionData.Action),
+		gocron.WithTags(cronDesc, actionData.Group+actionData.Action),
+		gocron.WithIdentifier(id),
+	}
+	switch s.Overlap {
+	case "skip":
+		options = append(options, gocron.WithSingletonMode(gocron.LimitModeReschedule))
+	case "queue":
+		options = append(options, gocron.WithSingletonMode(gocron.LimitModeWait))
 	}
 
-	sched, err = gocron.NewScheduler(gocron.WithLocation(loc))
+	_, err = sched.NewJob(
+		gocron.CronJob(cronExpr(s), s.Seconds),
+		gocron.NewTask(cronTask, actionData.Group, actionData.Action, s),
+		options...,
+	)
 	if err != nil {
 		return err
 	}
 
-	for k, v := range r {
-		for _, e := range v {
-			if len(e.Schedule) > 0 {
-				for _, c := range e.Schedule {
-					if validateInput(c, "cron") == nil {
-						var cronDesc string
-						exprDesc, err := cron.NewDescriptor()
-						if err == nil {
-							cronDesc, err = exprDesc.ToDescription(c, cron.Locale_en)
-							if err != nil {
-								cronDesc = ""
-							}
-						}
-						if !e.Disabled {
-							_, err = sched.NewJob(
-								gocron.CronJob(c, false),
-								gocron.NewTask(cronTask, e),
-								gocron.WithName(k+"/"+e.Action),
-								gocron.WithTags(cronDesc, e.Group+e.Action),
-							)
-						}
-						if err != nil {
-							return err
-						}
-					}
-				}
-			}
-		}
-	}
+	cronKeysMu.Lock()
+	cronKeys[id] = scheduleKey(actionData.Group, actionData.Action, s)
+	cronKeysMu.Unlock()
 
-	sched.Start()
+	return nil
+}
+
+// cronKey returns the schedule key of a cron job, empty for one-off jobs
+func cronKey(id uuid.UUID) string {
+	cronKeysMu.RLock()
+	defer cronKeysMu.RUnlock()
+
+	return cronKeys[id]
+}
+
+// removeCronKeys forgets the schedule keys of removed cron jobs, every job of group/action when id is uuid.Nil
+func removeCronKeys(group, action string, id uuid.UUID) {
+	cronKeysMu.Lock()
+	defer cronKeysMu.Unlock()
+
+	if id != uuid.Nil {
+		delete(cronKeys, id)
+		return
+	}
+	for k, key := range cronKeys {
+		if strings.HasPrefix(key, group+"/"+action+"/") {
+			delete(cronKeys, k)
+		}
+	}
+}
+
+// cronExpr returns the cron of a schedule prefixed with its timezone
+func cronExpr(s data.CronSchedule) string {
+	if s.Timezone != "" {
+		return "CRON_TZ=" + s.Timezone + " " + s.Cron
+	}
+
+	return s.Cron
+}
+
+// cronJobID is the job ID of a schedule of an action, it changes when any field of the schedule changes
+func cronJobID(group, action string, s data.CronSchedule) uuid.UUID {
+	spec, err := json.Marshal(s)
+	if err != nil {
+		spec = []byte(s.Cron)
+	}
+
+	return uuid.NewSHA1(uuid.NameSpaceOID, append([]byte(group+"/"+action+"/"), spec...))
+}
+
+// cronJob returns the job of sched with the ID, the action it runs and its schedule, one-off jobs aren't cron jobs
+func cronJob(id uuid.UUID) (gocron.Job, data.ActionData, data.CronSchedule, bool) {
+	for _, j := range sched.Jobs() {
+		if j.ID() != id || slices.Contains(j.Tags(), oneOffTag) {
+			continue
+		}
+		group, action, _ := strings.Cut(j.Name(), "/")
+		actionData := db.DBC.GetGroupAction(group, action)
+		for _, s := range actionData.Schedule {
+			if cronJobID(group, action, s) == id {
+				return j, actionData, s, true
+			}
+		}
+	}
+
+	return nil, data.ActionData{}, data.CronSchedule{}, false
+}
+
+// pausedBy returns what pauses a cron schedule, the scheduler, its group or the schedule itself by its schedule key,
+// empty if it isn't paused
+func pausedBy(paused map[string]bool, group, key string) string {
+	switch {
+	case paused[pausedScheduler]:
+		return pausedScheduler
+	case paused["group/"+group]:
+		return "group " + group
+	case paused["schedule/"+key]:
+		return "schedule " + scheduleName(key)
+	}
+
+	return ""
+}
+
+// cronSchedules returns the schedule of every cron job by job ID from the actions of groups
+func cronSchedules(groups map[string][]data.ActionData) map[uuid.UUID]data.CronSchedule {
+	schedules := make(map[uuid.UUID]data.CronSchedule)
+	for group, actions := range groups {
+		for _, e := range actions {
+			for _, s := range e.Schedule {
+				schedules[cronJobID(group, e.Action, s)] = s
+			}
+		}
+	}
+
+	return schedules
+}
+
+// syncSchedules diffs the cron jobs of sched against the schedules of enabled actions, removing jobs no longer
+// wanted and adding new ones. A schedule with the same cron but other options is replaced and reported as updated.
+// Every new schedule is validated before sched is changed, changes made before an error are still returned.
+// One-off jobs are left alone
+func syncSchedules(r map[string][]data.ActionData) (changes data.ScheduleSync, err error) {
+	changes = data.ScheduleSync{Added: []string{}, Removed: []string{}, Updated: []string{}}
+
+	type wantedJob struct {
+		actionData data.ActionData
+		schedule   data.CronSchedule
+	}
+
+	wanted := make(map[uuid.UUID]wantedJob)
+	wantedKeys := make(map[string]bool)
+	for group, v := range r {
+		for _, e := range v {
+			if e.Disabled {
+				continue
+			}
+			e.Group = group
+			for _, s := range e.Schedule {
+				if validateInput(s.Cron, "cron") == nil {
+					wanted[cronJobID(group, e.Action, s)] = wantedJob{actionData: e, schedule: s}
+					wantedKeys[scheduleKey(group, e.Action, s)] = true
+				}
+			}
+		}
+	}
+
+	var unwanted []gocron.Job
+	for _, j := range sched.Jobs() {
+		if slices.Contains(j.Tags(), oneOffTag) {
+			continue
+		}
+		if _, ok := wanted[j.ID()]; ok {
+			delete(wanted, j.ID())
+			continue
+		}
+		unwanted = append(unwanted, j)
+	}
+
+	for _, job := range wanted {
+		if err := gocron.NewDefaultCron(job.schedule.Seconds).IsValid(cronExpr(job.schedule), time.UTC, time.Now()); err != nil {
+			return changes, fmt.Errorf("error schedule %s of %s/%s %w", job.schedule.Cron, job.actionData.Group, job.actionData.Action, err)
+		}
+	}
+
+	// Removed schedules not added back are reported as removed and resumed, also when adding fails part way through
+	removed := make(map[string]bool)
+	defer func() {
+		for key := range removed {
+			changes.Removed = append(changes.Removed, scheduleName(key))
+			if wantedKeys[key] {
+				continue
+			}
+			if err := db.DBC.DeletePaused("schedule/" + key); err != nil {
+				logError("", "", err)
+			}
+		}
+		slices.Sort(changes.Added)
+		slices.Sort(changes.Removed)
+		slices.Sort(changes.Updated)
+	}()
+
+	for _, j := range unwanted {
+		if err := sched.RemoveJob(j.ID()); err != nil {
+			return changes, err
+		}
+		if key := cronKey(j.ID()); key != "" {
+			removed[key] = true
+		}
+		removeCronKeys("", "", j.ID())
+	}
+
+	for _, job := range wanted {
+		if err := scheduleCron(job.actionData, job.schedule); err != nil {
+			return changes, err
+		}
+		key := scheduleKey(job.actionData.Group, job.actionData.Action, job.schedule)
+		if removed[key] {
+			delete(removed, key)
+			changes.Updated = append(changes.Updated, scheduleName(key))
+			continue
+		}
+		changes.Added = append(changes.Added, scheduleName(key))
+	}
+
+	return changes, nil
+}
+
+// scheduleName turns a schedule key group/action/cron into group/action cron
+func scheduleName(key string) string {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) < 3 {
+		return key
+	}
+
+	return parts[0] + "/" + parts[1] + " " + parts[2]
+}
+
+func cronTask(group, action string, s data.CronSchedule) string {
+	// Only the leader runs schedules and records their last fire, a standby has nothing to catch up
+	if !isLeader() {
+		return "standby"
+	}
+
+	if err := db.DBC.PutLastFire(scheduleKey(group, action, s), time.Now()); err != nil {
+		logError("", "", err)
+	}
+
+	if by := pausedBy(db.DBC.GetPaused(), group, scheduleKey(group, action, s)); by != "" {
+		log.Printf("%s/%s schedule %s skipped, paused by %s", group, action, s.Cron, by)
+		return "paused"
+	}
+
+	if s.Jitter > 0 {
+		time.Sleep(time.Duration(mathrand.N(s.Jitter*1000+1)) * time.Millisecond) // #nosec G404
+	}
+
+	actionsData := db.DBC.GetGroupAction(group, action)
+
+	if actionsData.Action == "" || actionsData.Disabled {
+		return "error action disabled"
+	}
+
+	run, err := runAction(actionsData, newRun(actionsData, "schedule", s.Input), s.Input, "", nil)
+	if err != nil {
+		logError("", "", err)
+		return err.Error()
+	}
+
+	return run.Stdout
+}
+
+// scheduleKey identifies a schedule of an action by its cron and timezone, pauses and last fires are stored by it
+func scheduleKey(group, action string, s data.CronSchedule) string {
+	return group + "/" + action + "/" + cronExpr(s)
+}
+
+// catchUp applies the misfire policy of each schedule of an action to the fires it missed since it last fired, then
+// marks the schedules as checked up to now. Catch-up runs of every schedule run one at a time oldest first.
+// Only the leader catches up and records last fires
+func catchUp(actionData data.ActionData, loc *time.Location) {
+	if !isLeader() {
+		return
+	}
+	now := time.Now()
+
+	type misfire struct {
+		at    time.Time
+		input string
+	}
+
+	var missed []misfire
+	for _, s := range actionData.Schedule {
+		if validateInput(s.Cron, "cron") != nil {
+			continue
+		}
+		key := scheduleKey(actionData.Group, actionData.Action, s)
+		paused := pausedBy(db.DBC.GetPaused(), actionData.Group, key) != ""
+		if last := db.DBC.GetLastFire(key); !last.IsZero() && !paused {
+			runs := 0
+			switch s.Misfire.Policy {
+			case "run_once":
+				runs = 1
+			case "run_all":
+				runs = cmp.Or(s.Misfire.Limit, misfireLimit)
+			}
+
+			schedLoc := loc
+			if s.Timezone != "" {
+				if l, err := time.LoadLocation(s.Timezone); err == nil {
+					schedLoc = l
+				}
+			}
+			misfires, err := utils.Misfires(s.Cron, s.Seconds, schedLoc, last, now, max(runs, 1))
+			if err != nil {
+				logError("", "", fmt.Errorf("error misfires of %s: %w", key, err))
+			}
+			if len(misfires) > 0 {
+				runs = min(len(misfires), runs)
+				log.Printf("%s/%s missed schedule %s at %s, misfire policy %s runs %d", actionData.Group, actionData.Action,
+					s.Cron, misfires[0].Format(time.RFC3339), cmp.Or(s.Misfire.Policy, "skip"), runs)
+				for _, at := range misfires[:runs] {
+					missed = append(missed, misfire{at: at, input: s.Input})
+				}
+			}
+		}
+		if err := db.DBC.PutLastFire(key, now); err != nil {
+			logError("", "", err)
+		}
+	}
+
+	if len(missed) == 0 {
+		return
+	}
+	slices.SortFunc(missed, func(a, b misfire) int { return a.at.Compare(b.at) })
+
+	go func() {
+		for _, m := range missed {
+			actionsData := db.DBC.GetGroupAction(actionData.Group, actionData.Action)
+			_, err := runAction(actionsData, newRun(actionsData, "misfire", m.input), m.input, "", nil)
+			if err != nil {
+				logError("", "", err)
+			}
+		}
+	}()
+}
+
+// runAt returns the time of a one-off run from an RFC3339 at or a delay duration from now
+func runAt(at, delay string) (time.Time, error) {
+	if at != "" && delay != "" {
+		return time.Time{}, errors.New("error set at or delay, not both")
+	}
+
+	if delay != "" {
+		d, err := time.ParseDuration(delay)
+		if err != nil || d <= 0 {
+			return time.Time{}, errors.New("error delay is not a positive duration e.g. 30m")
+		}
+		return time.Now().Add(d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, at)
+	if err != nil {
+		return time.Time{}, errors.New("error at is not an RFC3339 time")
+	}
+	if !t.After(time.Now()) {
+		return time.Time{}, errors.New("error at is in the past")
+	}
+
+	return t, nil
+}
+
+// scheduleOneOff adds a one-time job with the ID of the one-off, one-offs missed while pal was down run right away
+func scheduleOneOff(oneOff data.OneOff) error {
+	id, err := uuid.Parse(oneOff.ID)
+	if err != nil {
+		return err
+	}
+
+	start := gocron.OneTimeJobStartDateTime(oneOff.At)
+	if !oneOff.At.After(time.Now()) {
+		start = gocron.OneTimeJobStartImmediately()
+	}
+
+	_, err = sched.NewJob(
+		gocron.OneTimeJob(start),
+		gocron.NewTask(oneOffTask, oneOff.ID),
+		gocron.WithName(oneOff.Group+"/"+oneOff.Action),
+		gocron.WithTags("once at "+oneOff.At.Format(time.RFC3339), oneOffTag),
+		gocron.WithIdentifier(id),
+	)
+
+	return err
+}
+
+func oneOffTask(id string) {
+	oneOff, err := db.DBC.GetOneOff(id)
+	if err != nil {
+		logError("", "", err)
+		return
+	}
+	if err := db.DBC.DeleteOneOff(id); err != nil {
+		logError("", "", err)
+	}
+	// A one-time job stays in the scheduler without a next run after it ran
+	if jobID, err := uuid.Parse(id); err == nil {
+		go func() { _ = sched.RemoveJob(jobID) }()
+	}
+
+	actionData := db.DBC.GetGroupAction(oneOff.Group, oneOff.Action)
+	if actionData.Action == "" || actionData.Disabled {
+		logError("", "", fmt.Errorf("error one-off %s skipped %s/%s is disabled or missing", id, oneOff.Group, oneOff.Action))
+		return
+	}
+
+	_, err = runAction(actionData, newRun(actionData, "schedule", oneOff.Input), oneOff.Input, "", nil)
+	if err != nil {
+		logError("", "", err)
+	}
+}
+
+// DeleteSchedule cancels a one-off schedule
+func DeleteSchedule(c *echo.Context) error {
+	if !sessionValid(c) && !checkBasicAuth(c) {
+		return c.JSON(http.StatusUnauthorized, data.GenericResponse{Err: "Unauthorized no valid session or basic auth."})
+	}
+
+	if !isAdminExec(c, "") {
+		return c.JSON(http.StatusForbidden, data.GenericResponse{Err: "error role is not admin or execute"})
+	}
+
+	id := c.Param("id")
+	if _, err := db.DBC.GetOneOff(id); err != nil {
+		return c.JSON(http.StatusNotFound, data.GenericResponse{Err: "error one-off schedule not found"})
+	}
+
+	if jobID, err := uuid.Parse(id); err == nil {
+		_ = sched.RemoveJob(jobID)
+	}
+	if err := db.DBC.DeleteOneOff(id); err != nil {
+		return c.JSON(http.StatusInternalServerError, data.GenericResponse{Err: err.Error()})
+	}
+
+	if strings.HasPrefix(c.Request().RequestURI, "/v1/pal/ui") {
+		return c.Redirect(http.StatusSeeOther, "/v1/pal/ui/schedules")
+	}
+
+	return c.JSON(http.StatusOK, data.GenericResponse{Msg: "cancelled one-off schedule " + id})
+}
+
+// PauseSchedules pauses or resumes the cron schedule of the id query param, the cron schedules of the group
+// query param, or the whole scheduler without either. Paused schedules skip their runs, one-offs aren't paused
+func PauseSchedules(c *echo.Context) error {
+	if !sessionValid(c) && !checkBasicAuth(c) {
+		return c.JSON(http.StatusUnauthorized, data.GenericResponse{Err: "Unauthorized no valid session or basic auth."})
+	}
+
+	if !isAdminExec(c, "") {
+		return c.JSON(http.StatusForbidden, data.GenericResponse{Err: "error role is not admin or execute"})
+	}
+
+	id := c.QueryParam("id")
+	group := c.QueryParam("group")
+
+	var key, name string
+	switch {
+	case id != "" && group != "":
+		return c.JSON(http.StatusBadRequest, data.GenericResponse{Err: "error set id or group, not both"})
+	case id != "":
+		jobID, err := uuid.Parse(id)
+		if err != nil {
+			return c.JSON(http.StatusNotFound, data.GenericResponse{Err: "error schedule not found"})
+		}
+		_, actionData, s, ok := cronJob(jobID)
+		if !ok {
+			return c.JSON(http.StatusNotFound, data.GenericResponse{Err: "error schedule not found"})
+		}
+		// Paused by the schedule key, the job ID changes when other options of the schedule change
+		key, name = "schedule/"+scheduleKey(actionData.Group, actionData.Action, s), "schedule "+id
+	case group != "":
+		if len(db.DBC.GetGroupActions(group)) == 0 {
+			return c.JSON(http.StatusNotFound, data.GenericResponse{Err: errorGroup})
+		}
+		key, name = "group/"+group, "group "+group
+	default:
+		key, name = pausedScheduler, pausedScheduler
+	}
+
+	pause := strings.HasSuffix(c.Path(), "/pause")
+	var err error
+	if pause {
+		err = db.DBC.PutPaused(key)
+	} else {
+		err = db.DBC.DeletePaused(key)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, data.GenericResponse{Err: err.Error()})
+	}
+
+	if strings.HasPrefix(c.Request().RequestURI, "/v1/pal/ui") {
+		return c.Redirect(http.StatusSeeOther, "/v1/pal/ui/schedules")
+	}
+
+	if pause {
+		return c.JSON(http.StatusOK, data.GenericResponse{Msg: "paused " + name})
+	}
+
+	return c.JSON(http.StatusOK, data.GenericResponse{Msg: "resumed " + name})
+}
+
+// GetSchedulePreview returns the next fire times of the cron schedule of the id query param, or of the cron
+// query param with seconds and timezone
+func GetSchedulePreview(c *echo.Context) error {
+	if !sessionValid(c) && !checkBasicAuth(c) {
+		return c.JSON(http.StatusUnauthorized, data.GenericResponse{Err: "Unauthorized no valid session or basic auth."})
+	}
+
+	count := previewCount
+	if c.QueryParam("count") != "" {
+		var err error
+		count, err = strconv.Atoi(c.QueryParam("count"))
+		if err != nil || count < 1 || count > previewLimit {
+			return c.JSON(http.StatusBadRequest, data.GenericResponse{Err: fmt.Sprintf("error count is not 1 to %d", previewLimit)})
+		}
+	}
+
+	if id := c.QueryParam("id"); id != "" {
+		jobID, err := uuid.Parse(id)
+		if err != nil {
+			return c.JSON(http.StatusNotFound, data.GenericResponse{Err: "error schedule not found"})
+		}
+		job, actionData, s, ok := cronJob(jobID)
+		if !ok {
+			return c.JSON(http.StatusNotFound, data.GenericResponse{Err: "error schedule not found"})
+		}
+		nextRuns, err := job.NextRuns(count)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, data.GenericResponse{Err: err.Error()})
+		}
+		return c.JSON(http.StatusOK, data.SchedulePreview{
+			ID:       id,
+			Group:    actionData.Group,
+			Action:   actionData.Action,
+			Cron:     s.Cron,
+			Seconds:  s.Seconds,
+			Timezone: cmp.Or(s.Timezone, config.GetConfigStr("global_timezone")),
+			Paused:   pausedBy(db.DBC.GetPaused(), actionData.Group, scheduleKey(actionData.Group, actionData.Action, s)) != "",
+			NextRuns: nextRuns,
+		})
+	}
+
+	expr := c.QueryParam("cron")
+	if expr == "" {
+		return c.JSON(http.StatusBadRequest, data.GenericResponse{Err: "error set id or cron"})
+	}
+
+	timezone := cmp.Or(c.QueryParam("timezone"), config.GetConfigStr("global_timezone"))
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, data.GenericResponse{Err: "error unknown timezone " + timezone})
+	}
+
+	seconds := c.QueryParam("seconds") == "true"
+	nextRuns, err := utils.NextRuns(expr, seconds, loc, time.Now(), count)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, data.GenericResponse{Err: "error invalid cron " + err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, data.SchedulePreview{
+		Cron:     expr,
+		Seconds:  seconds,
+		Timezone: timezone,
+		NextRuns: nextRuns,
+	})
+}
+
+// isLeader returns true when this node runs cron schedules, every node does without leader election
+func isLeader() bool {
+	return elector == nil || elector.IsLeader(context.Background()) == nil
+}
+
+// SetElector sets the leader election of the scheduler in place of pal.yml leader.type, call it before ScheduleStart
+func SetElector(e Elector) {
+	elector = e
+}
+
+// startElector starts the leader election of pal.yml leader.type, unless one was set with SetElector
+func startElector() {
+	if elector == nil && config.GetConfigStr("leader_type") == "file" {
+		lease := utils.NewFileLease(config.GetConfigStr("leader_lease_file"), config.GetConfigStr("leader_node"),
+			time.Duration(config.GetConfigInt("leader_ttl"))*time.Second)
+		lease.Start()
+		elector = lease
+	}
+}
+
+func ScheduleStart(r map[string][]data.ActionData) error {
+	loc, err := time.LoadLocation(config.GetConfigStr("global_timezone"))
+	if err != nil {
+		return err
+	}
+
+	startElector()
+
+	sched, err = gocron.NewScheduler(gocron.WithLocation(loc))
+	if err != nil {
+		return err
+	}
+
+	if _, err := syncSchedules(r); err != nil {
+		return err
+	}
+
+	for _, v := range r {
+		for _, e := range v {
+			if len(e.Schedule) > 0 && !e.Disabled {
+				catchUp(e, loc)
+			}
+		}
+	}
+
+	for _, oneOff := range db.DBC.GetOneOffs() {
+		if err := scheduleOneOff(oneOff); err != nil {
+			logError("", "", fmt.Errorf("error scheduling one-off %s: %w", oneOff.ID, err))
+		}
+	}
+
+	sched.Start()
+
+	return nil
+}
+
+// watchTarget is an action watching a directory, or a single file of it
+type watchTarget struct {
+	group  string
+	action string
+	file   string
+	watch  data.Watch
+}
+
+// WatchStart watches the paths of actions with watch configured, replacing the watches of a previous call
+func WatchStart(r map[string][]data.ActionData) error {
+	watchMu.Lock()
+	defer watchMu.Unlock()
+
+	if watcher != nil {
+		if err := watcher.Close(); err != nil {
+			logError("", "", err)
+		}
+		watcher = nil
+	}
+	for k, t := range watchTimers {
+		t.Stop()
+		delete(watchTimers, k)
+	}
+
+	targets := make(map[string][]watchTarget)
+	for k, v := range r {
+		for _, e := range v {
+			paths := e.Watch.Paths
+			if e.Watch.UploadDir {
+				paths = append(slices.Clone(paths), config.GetConfigStr("http_upload_dir"))
+			}
+			if _, err := filepath.Match(e.Watch.Glob, ""); err != nil {
+				log.Println("error watch glob " + k + "/" + e.Action + ": " + err.Error())
+				continue
+			}
+			for _, p := range paths {
+				dir, err := filepath.Abs(p)
+				if err != nil {
+					log.Println("error watch path " + k + "/" + e.Action + ": " + err.Error())
+					continue
+				}
+				target := watchTarget{group: k, action: e.Action, watch: e.Watch}
+				// Watch the directory of a file, files are often replaced instead of written to
+				if info, err := os.Stat(dir); err == nil && !info.IsDir() {
+					target.file = filepath.Base(dir)
+					dir = filepath.Dir(dir)
+				}
+				targets[dir] = append(targets[dir], target)
+			}
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	for dir := range targets {
+		if err := w.Add(dir); err != nil {
+			log.Println("error watching path " + dir + ": " + err.Error())
+		}
+	}
+
+	watcher = w
+	go watchEvents(w, targets)
+
+	return nil
+}
+
+func watchEvents(w *fsnotify.Watcher, targets map[string][]watchTarget) {
+	for {
+		select {
+		case event, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			var op string
+			switch {
+			case event.Has(fsnotify.Create):
+				op = "create"
+			case event.Has(fsnotify.Write):
+				op = "modify"
+			case event.Has(fsnotify.Remove), event.Has(fsnotify.Rename):
+				op = "delete"
+			default:
+				continue
+			}
+			for _, t := range targets[filepath.Dir(event.Name)] {
+				if watchMatch(t, event.Name, op) {
+					debounceWatch(t, event.Name)
+				}
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			logError("", "", err)
+		}
+	}
+}
+
+func watchMatch(t watchTarget, name, op string) bool {
+	base := filepath.Base(name)
+	if t.file != "" && base != t.file {
+		return false
+	}
+	if t.watch.Glob != "" {
+		if ok, _ := filepath.Match(t.watch.Glob, base); !ok {
+			return false
+		}
+	}
+
+	return len(t.watch.Events) == 0 || slices.Contains(t.watch.Events, op)
+}
+
+// debounceWatch runs the action once events for the path stop for the debounce time
+func debounceWatch(t watchTarget, name string) {
+	key := t.group + "/" + t.action + ":" + name
+	delay := time.Duration(cmp.Or(t.watch.Debounce, defaultDebounce)) * time.Millisecond
+
+	watchMu.Lock()
+	defer watchMu.Unlock()
+
+	if timer, ok := watchTimers[key]; ok {
+		timer.Reset(delay)
+		return
+	}
+
+	watchTimers[key] = time.AfterFunc(delay, func() {
+		watchMu.Lock()
+		delete(watchTimers, key)
+		watchMu.Unlock()
+		watchTask(t.group, t.action, name)
+	})
+}
+
+func watchTask(group, action, name string) {
+	actionData := db.DBC.GetGroupAction(group, action)
+
+	if actionData.Action == "" || actionData.Disabled {
+		return
+	}
+
+	if err := validateInput(name, actionData.InputValidate); err != nil {
+		logError("", "", fmt.Errorf("error with input validation %s/%s: %w", group, action, err))
+		return
+	}
+
+	_, err := runAction(actionData, newRun(actionData, "watch", name), name, "", nil)
+	if err != nil {
+		logError("", "", err)
+	}
+}
+
+func mergeGroup(action data.ActionData) {
+	groupsMu.Lock()
+	defer groupsMu.Unlock()
+	// Dont use PutGroupAction instead, set Action field in ActionData
+	groupsData := db.DBC.GetGroups()
+	if v, ok := groupsData[action.Group]; ok {
+		for i, e := range v {
+			if e.Action == action.Action {
+				// Add to the stored run history, other runs and skipped triggers may have added to it since this run started
+				action.RunHistory = e.RunHistory
+				v[i] = addRun(action, data.RunHistory{
+					ID:       action.LastRunID,
+					Ran:      action.LastRan,
+					Duration: action.LastDuration,
+					Status:   action.Status,
+					ExitCode: action.LastExitCode,
+				})
+				groupsData[action.Group] = v
+				err := db.DBC.PutGroups(groupsData)
+				if err != nil {
+					// TODO: DEBUG STATEMENT
+					log.Println(err.Error())
+				}
+				return
+			}
+		}
+	}
+}
+
+func addRun(action data.ActionData, run data.RunHistory) data.ActionData {
+	action.RunHistory = append([]data.RunHistory{run}, action.RunHistory...)
+
+	// more than 5 items, remove the last one the oldest
+	if len(action.RunHistory) > runHistoryLimit {
+		// create a new slice containing all elements except the last one.
+		action.RunHistory = action.RunHistory[:len(action.RunHistory)-1]
+	}
+
+	return action
+}
+
+func putNotifications(notification data.Notification) error {
+	notifications := db.DBC.GetNotifications("", "")
+
+	if len(notifications) >= config.GetConfigInt("notifications_store_max") {
+		notifications = notifications[:len(notifications)-1]
+	}
+
+	notification.ID = uuid.NewString()
+	notification.NotificationRcv = utils.TimeNow(config.GetConfigStr("global_timezone"))
+
+	notifications = append([]data.Notification{notification}, notifications...)
+
+	return db.DBC.PutNotifications(notifications)
+}
+
+func sendWebhookNotifications(actionData data.ActionData, run data.RunRecord) {
+	webhooks := config.GetConfigWebHooks()
+
+	var webhookNames []string
+	if actionData.Status != "success" {
+		webhookNames = actionData.OnError.Webhook
+	} else {
+		webhookNames = actionData.OnSuccess.Webhook
+	}
+
+	// Iterate over each configured webhook
+	for _, webhook := range webhooks {
+		for _, name := range webhookNames {
+			if name == webhook.Name {
+				// $PAL_* variables are escaped to stay valid inside the JSON strings of a JSON body
+				var escape func(string) string
+				for _, h := range webhook.Headers {
+					if strings.EqualFold(h.Header, echo.HeaderContentType) && strings.Contains(h.Value, "json") {
+						escape = jsonEscape
+					}
+				}
+				notification := renderTemplate(webhook.Body, newTemplateData(actionData, run), escape)
+
+				log.Printf("Sending webhook notification to: %s\n", webhook.Name)
+
+				ctx, cancel := context.WithTimeout(context.Background(), httpClientTimeout*time.Second)
+
+				// Create a new request using the method, URL, and passed-in body
+				req, err := http.NewRequestWithContext(ctx, webhook.Method, webhook.URL, bytes.NewBufferString(notification))
+				if err != nil {
+					log.Printf("Error creating webhook request for %s: %v", webhook.Name, err)
+					cancel()
+					continue
+				}
+
+				// Add all configured headers to the request
+				for _, h := range webhook.Headers {
+					req.Header.Set(h.Header, h.Value)
+				}
+
+				transport := &http2.Transport{
+					TLSClientConfig: &tls.Config{
+						InsecureSkipVerify: webhook.Insecure,
+						MinVersion:         tls.VersionTLS13,
+					},
+				}
+
+				client := &http.Client{
+					Transport: transport,
+					Timeout:   httpClientTimeout * time.Second,
+				}
+
+				// Execute the request
+				resp, err := client.Do(req)
+				if err != nil {
+					log.Printf("Error sending webhook request to %s: %v", webhook.Name, err)
+					cancel()
+					continue
+				}
+
+				resp.Body.Close()
+				cancel()
+			}
+		}
+	}
+}
+
+func validateInput(input, inputValidate string) error {
+	return validate.Var(input, inputValidate)
+}
+
+func GetRunning(c *echo.Context) error {
+	if !sessionValid(c) && !checkBasicAuth(c) {
+		return c.Redirect(http.StatusSeeOther, "/v1/pal/ui/login")
+	}
+	// Detailed worker occupancy with running and queued runs by action
+	if c.QueryParam("details") == "true" {
+		running := []data.RunningAction{}
+		for group, actions := range db.DBC.GetGroups() {
+			for _, e := range actions {
+				runningAction := data.RunningAction{
+					Group:   group,
+					Action:  e.Action,
+					Limit:   runLimit(e),
+					Running: db.GetRunningIDs(group + "_" + e.Action),
+					Queue:   []data.QueuedRun{},
+				}
+				for i, id := range db.GetQueued(group + "_" + e.Action) {
+					runningAction.Queue = append(runningAction.Queue, data.QueuedRun{ID: id, Position: i + 1})
+				}
+				runningAction.QueueLength = len(runningAction.Queue)
+				if len(runningAction.Running) > 0 || runningAction.QueueLength > 0 {
+					running = append(running, runningAction)
+				}
+			}
+		}
+		slices.SortFunc(running, func(a, b data.RunningAction) int {
+			return cmp.Or(cmp.Compare(a.Group, b.Group), cmp.Compare(a.Action, b.Action))
+		})
+		workers := data.Workers{}
+		workers.Max, workers.Busy, workers.Waiting = db.GetWorkers()
+
+		return c.JSON(http.StatusOK, data.RunningActions{Workers: workers, Actions: running})
+	}
+
+	return c.JSON(http.StatusOK, db.GetRunning())
+}
+
+func GetRuns(c *echo.Context) error {
+	if !sessionValid(c) && !checkBasicAuth(c) {
+		return c.JSON(http.StatusUnauthorized, data.GenericResponse{Err: "Unauthorized no valid session or basic auth."})
+	}
+
+	var since time.Time
+	if sinceParam := c.QueryParam("since"); sinceParam != "" {
+		var err error
+		since, err = time.Parse(time.RFC3339, sinceParam)
+		if err != nil {
+			ago, err := time.ParseDuration(sinceParam)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, data.GenericResponse{Err: "error since must be an RFC3339 time or duration e.g. 24h"})
+			}
+			since = time.Now().Add(-ago)
+		}
+	}
+
+	runs := db.DBC.GetRuns(c.QueryParam("group"), c.QueryParam("action"), c.QueryParam("workflow"), c.QueryParam("status"), since)
+	for i := range runs {
+		if runs[i].Status == "queued" {
+			runs[i].QueuePosition, runs[i].QueueLength = db.GetQueuePosition(runs[i].ID)
+		}
+	}
+
+	return c.JSON(http.StatusOK, runs)
+}
+
+func GetRun(c *echo.Context) error {
+	if !sessionValid(c) && !checkBasicAuth(c) {
+		return c.JSON(http.StatusUnauthorized, data.GenericResponse{Err: "Unauthorized no valid session or basic auth."})
+	}
+
+	run, err := db.DBC.GetRun(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, data.GenericResponse{Err: errorRunNotFound})
+	}
+
+	if run.Status == "queued" {
+		run.QueuePosition, run.QueueLength = db.GetQueuePosition(run.ID)
+	}
+
+	return c.JSON(http.StatusOK, run)
+}
+
+// CancelRun cancels a running run by id and kills its process tree or removes a queued run from the queue
+func CancelRun(c *echo.Context) error {
+	if !sessionValid(c) && !checkBasicAuth(c) {
+		return c.JSON(http.StatusUnauthorized, data.GenericResponse{Err: "Unauthorized no valid session or basic auth."})
+	}
+
+	if !isAdminExec(c, "") {
+		return c.JSON(http.StatusForbidden, data.GenericResponse{Err: "error role is not admin or execute"})
+	}
+
+	id := c.Param("id")
+	if !db.CancelRunning(id) && !db.CancelQueued(id) {
+		if _, err := db.DBC.GetRun(id); err != nil {
+			return c.JSON(http.StatusNotFound, data.GenericResponse{Err: errorRunNotFound})
+		}
+		return c.JSON(http.StatusConflict, data.GenericResponse{Err: errorRunNotRunning})
+	}
+
+	return c.JSON(http.StatusOK, data.GenericResponse{Msg: "cancelled run " + id})
+}
+
+// DecideRun approves or denies a run pending approval
+func DecideRun(c *echo.Context) error {
+	if !sessionValid(c) && !checkBasicAuth(c) {
+		return c.JSON(http.StatusUnauthorized, data.GenericResponse{Err: "Unauthorized no valid session or basic auth."})
+	}
+
+	id := c.Param("id")
+	run, err := db.DBC.GetRun(id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, data.GenericResponse{Err: errorRunNotFound})
+	}
+
+	approved := strings.HasSuffix(c.Path(), "/approve")
+	user, role := currentUser(c)
+	actionData := db.DBC.GetGroupAction(run.Group, run.Action)
+
+	if !canApprove(actionData.Approval, user, role) {
+		return c.JSON(http.StatusForbidden, data.GenericResponse{Err: "error user is not an approver"})
+	}
+	if approved && run.RequestedBy != "" && run.RequestedBy == user {
+		return c.JSON(http.StatusForbidden, data.GenericResponse{Err: "error requester can't approve their own run"})
+	}
+
+	if !db.DecideApproval(id, db.Approval{Approved: approved, User: user}) {
+		return c.JSON(http.StatusConflict, data.GenericResponse{Err: errorRunNotPending})
+	}
+
+	if strings.HasPrefix(c.Request().RequestURI, "/v1/pal/ui") {
+		return c.Redirect(http.StatusSeeOther, "/v1/pal/ui/action/"+run.Group+"/"+run.Action)
+	}
+
+	if approved {
+		return c.JSON(http.StatusOK, data.GenericResponse{Msg: "approved run " + id})
+	}
+
+	return c.JSON(http.StatusOK, data.GenericResponse{Msg: "denied run " + id})
+}
+
+// GetWorkflows returns the workflow definitions sorted by name
+func GetWorkflows(c *echo.Context) error {
+	if !sessionValid(c) && !checkBasicAuth(c) {
+		return c.JSON(http.StatusUnauthorized, data.GenericResponse{Err: "Unauthorized no valid session or basic auth."})
+	}
+
+	workflows := []data.Workflow{}
+	for _, e := range config.GetConfigWorkflows() {
+		if e.AuthHeader != "" {
+			e.AuthHeader = "hidden"
+		}
+		workflows = append(workflows, e)
+	}
+	slices.SortFunc(workflows, func(a, b data.Workflow) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	return c.JSON(http.StatusOK, workflows)
+}
+
+// RunWorkflow runs a workflow and returns its run record once every step is done, or right away with background=true
+func RunWorkflow(c *echo.Context) error {
+	workflow, ok := config.GetConfigWorkflows()[c.Param("name")]
+	if !ok {
+		return c.String(http.StatusBadRequest, errorWorkflow)
+	}
+
+	ui := strings.HasPrefix(c.Request().RequestURI, "/v1/pal/ui")
+
+	// Check if auth header is present and if the header is correct
+	if workflow.AuthHeader != "" {
+		authPass := false
+		if ui {
+			if !sessionValid(c) && !checkBasicAuth(c) {
+				return c.Redirect(http.StatusSeeOther, "/v1/pal/ui/login")
+			}
+			authPass = true
+		}
+		for k, v := range c.Request().Header {
+			if strings.Join([]string{k, v[0]}, " ") == workflow.AuthHeader {
+				authPass = true
+			}
+		}
+
+		if !authPass {
+			return c.String(http.StatusUnauthorized, errorAuth)
+		}
 
-	return nil
+		if !isAdminExec(c, workflow.AuthHeader) {
+			return c.String(http.StatusForbidden, "error role is not admin or execute")
+		}
+	}
+
+	var input string
+	if c.Request().Method == http.MethodPost {
+		bodyBytes, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "error reading request body in post run")
+		}
+		input = string(bodyBytes)
+	} else {
+		input = c.QueryParam("input")
+	}
+
+	if input == "" {
+		input = workflow.Input
+	}
+
+	input = strings.TrimSpace(input)
+
+	trigger := "http"
+	if ui {
+		trigger = "ui"
+	}
+
+	run := newWorkflowRun(workflow, trigger, input)
+	c.Response().Header().Set(headerRunID, run.ID)
+
+	if ui || c.QueryParam("background") == "true" {
+		go runWorkflow(workflow, run, input)
+
+		if ui {
+			return c.Redirect(http.StatusSeeOther, "/v1/pal/ui/workflows")
+		}
+
+		return c.JSON(http.StatusAccepted, data.GenericResponse{Msg: "running workflow in background"})
+	}
+
+	run = runWorkflow(workflow, run, input)
+	if run.Status != "success" {
+		return c.JSON(http.StatusInternalServerError, run)
+	}
+
+	return c.JSON(http.StatusOK, run)
+}
+
+// GetWorkflowsPage shows the workflow steps with the step status of the last run
+func GetWorkflowsPage(c *echo.Context) error {
+	if !sessionValid(c) && !checkBasicAuth(c) {
+		return c.Redirect(http.StatusSeeOther, "/v1/pal/ui/login")
+	}
+
+	type workflows struct {
+		Workflow data.Workflow
+		LastRun  data.RunRecord
+		Runs     []data.RunRecord
+	}
+
+	wfs := []workflows{}
+	for _, e := range config.GetConfigWorkflows() {
+		runs := db.DBC.GetRuns("", "", e.Name, "", time.Time{})
+		runs = runs[:min(len(runs), runHistoryLimit)]
+		for runIndex, run := range runs {
+			parsedTime, err := time.Parse(time.RFC3339, run.Started)
+			if err == nil {
+				runs[runIndex].Started = humanize.Time(parsedTime)
+			}
+		}
+
+		wf := workflows{Workflow: e, Runs: runs}
+		if len(runs) > 0 {
+			wf.LastRun = runs[0]
+		}
+		wfs = append(wfs, wf)
+	}
+	slices.SortFunc(wfs, func(a, b workflows) int {
+		return cmp.Compare(a.Workflow.Name, b.Workflow.Name)
+	})
+
+	uiData := struct {
+		Workflows     []workflows
+		Notifications int
+	}{
+		Workflows:     wfs,
+		Notifications: len(db.DBC.GetNotifications("", "")),
+	}
+
+	return c.Render(http.StatusOK, "workflows.tmpl", uiData)
+}
+
+func requestJSON(c *echo.Context, input string) (string, error) {
+	type RequestData struct {
+		Method      string              `json:"method"`
+		URL         string              `json:"url"`
+		Headers     map[string]string   `json:"headers"`
+		QueryParams map[string][]string `json:"query_params"`
+		Body        string              `json:"body"`
+	}
+
+	requestData := RequestData{
+		Method:      c.Request().Method,
+		URL:         c.Request().URL.String(),
+		Headers:     make(map[string]string),
+		QueryParams: c.QueryParams(),
+		Body:        input,
+	}
+
+	for key, values := range c.Request().Header {
+		requestData.Headers[key] = strings.Join(values, ", ")
+	}
+
+	jsonData, err := json.Marshal(requestData)
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonData), nil
+}
+
+// sseStream sends live cmd output to the client as Server-Sent Events
+type sseStream struct {
+	mu     sync.Mutex
+	c      *echo.Context
+	stdout *sseWriter
+	stderr *sseWriter
+}
+
+// sseWriter sends each line written as an event to the stream
+type sseWriter struct {
+	stream *sseStream
+	event  string
+	buf    []byte
+}
+
+func newSSEStream(c *echo.Context) *sseStream {
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set(echo.HeaderCacheControl, "no-cache")
+	res.Header().Set(echo.HeaderConnection, "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+
+	// Long running cmds would otherwise be cut off by the server write timeout
+	_ = http.NewResponseController(res).SetWriteDeadline(time.Time{})
+
+	res.WriteHeader(http.StatusOK)
+	_ = http.NewResponseController(res).Flush()
+
+	stream := &sseStream{c: c}
+	stream.stdout = &sseWriter{stream: stream, event: "stdout"}
+	stream.stderr = &sseWriter{stream: stream, event: "stderr"}
+
+	return stream
+}
+
+func (s *sseStream) send(event, msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("event: " + event + "\n")
+	for _, line := range strings.Split(msg, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+
+	// Client may have gone away, keep running the cmd regardless
+	res := s.c.Response()
+	if _, err := io.WriteString(res, b.String()); err != nil {
+		return
+	}
+	_ = http.NewResponseController(res).Flush()
+}
+
+// close sends any remaining partial lines, an error if not empty and the final status with the exit code
+func (s *sseStream) close(status, errMsg string, exitCode int) {
+	s.stdout.flush()
+	s.stderr.flush()
+	if errMsg != "" {
+		s.send("error", errMsg)
+	}
+
+	// Headers are already flushed when streaming, the exit code can only be sent in the stream
+	done, _ := json.Marshal(struct {
+		Status   string `json:"status"`
+		ExitCode int    `json:"exit_code"`
+	}{Status: status, ExitCode: exitCode})
+	s.send("done", string(done))
+}
+
+func (w *sseWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.stream.send(w.event, strings.TrimSuffix(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (w *sseWriter) flush() {
+	if len(w.buf) > 0 {
+		w.stream.send(w.event, string(w.buf))
+		w.buf = nil
+	}
+}
+
+// actionParams validates input against the params of an action, the schema is compiled once when actions are loaded
+func actionParams(actionData data.ActionData, input string) (map[string]string, error) {
+	if len(actionData.Params) == 0 {
+		return nil, nil
+	}
+
+	paramsMu.Lock()
+	key := actionData.Group + "/" + actionData.Action
+	schema, ok := paramSchemas[key]
+	if !ok {
+		var err error
+		schema, err = utils.CompileParams(actionData.Params)
+		if err != nil {
+			paramsMu.Unlock()
+			return nil, err
+		}
+		paramSchemas[key] = schema
+	}
+	paramsMu.Unlock()
+
+	return utils.Params(actionData.Params, schema, input)
+}
+
+// compileParams replaces the compiled params schemas with the schemas of the loaded actions
+func compileParams(groups map[string][]data.ActionData) {
+	schemas := make(map[string]*jsonschema.Schema)
+	for group, actions := range groups {
+		for _, e := range actions {
+			if len(e.Params) == 0 {
+				continue
+			}
+			schema, err := utils.CompileParams(e.Params)
+			if err != nil {
+				logError("", "", err)
+				continue
+			}
+			schemas[group+"/"+e.Action] = schema
+		}
+	}
+
+	paramsMu.Lock()
+	paramSchemas = schemas
+	paramsMu.Unlock()
+}
+
+// cmdString returns the shell cmd of an action, values are passed in the env and never written into the cmd
+func cmdString(actionData data.ActionData, name string, env []string) string {
+	var cmd string
+	if actionData.Container.Image != "" {
+		containerCmd := config.GetConfigStr("global_container_cmd")
+		cmd = fmt.Sprintf("%s run --rm --name %s %s %s %s %s '%s'", containerCmd, name, strings.Join(containerEnv(env), " "), actionData.Container.Options, actionData.Container.Image, config.GetConfigStr("global_cmd_prefix"), actionData.Cmd)
+	} else {
+		cmd = actionData.Cmd
+	}
+	// TODO: Add Debug cmd output
+	return cmd
+}
+
+// cmdArgs returns the argv of an args action with pal variables substituted inside each arg,
+// the args are never parsed by a shell so every arg stays a single argument
+func cmdArgs(actionData data.ActionData, name string, env []string) []string {
+	vars := [][2]string{}
+	for _, e := range env {
+		name, value, _ := strings.Cut(e, "=")
+		if strings.HasPrefix(name, "PAL_") {
+			vars = append(vars, [2]string{name, value})
+		}
+	}
+	// The replacer tries names in order, the longest goes first so $PAL_PARAM_ENV doesn't match $PAL_PARAM_ENVIRONMENT
+	slices.SortStableFunc(vars, func(a, b [2]string) int {
+		return len(b[0]) - len(a[0])
+	})
+	oldnew := []string{}
+	for _, v := range vars {
+		oldnew = append(oldnew, "${"+v[0]+"}", v[1], "$"+v[0], v[1])
+	}
+	replacer := strings.NewReplacer(oldnew...)
+
+	args := []string{}
+	if actionData.Container.Image != "" {
+		args = append(args, config.GetConfigStr("global_container_cmd"), "run", "--rm", "--name", name)
+		args = append(args, containerEnv(env)...)
+		args = append(args, strings.Fields(actionData.Container.Options)...)
+		args = append(args, actionData.Container.Image)
+	}
+
+	for _, arg := range actionData.Args {
+		args = append(args, replacer.Replace(arg))
+	}
+
+	return args
+}
+
+// containerEnv returns -e flags with only the env names, the container runtime reads the values from its own env
+func containerEnv(env []string) []string {
+	flags := []string{}
+	seen := map[string]bool{}
+	for _, e := range env {
+		name, _, _ := strings.Cut(e, "=")
+		if !seen[name] {
+			seen[name] = true
+			flags = append(flags, "-e", name)
+		}
+	}
+
+	return flags
+}
+
+// cmdEnv returns the env of a cmd, global, group and action env_file and env in that order so the
+// most specific wins, then the pal variables which can't be overridden
+func cmdEnv(actionData data.ActionData, run data.RunRecord, input, req string) ([]string, error) {
+	groupConfig := config.GetConfigGroup(actionData.Group)
+	levels := []struct {
+		envFile string
+		env     map[string]string
+	}{
+		{config.GetConfigStr("global_env_file"), config.GetConfigEnv()},
+		{groupConfig.EnvFile, groupConfig.Env},
+		{actionData.EnvFile, actionData.Env},
+	}
+
+	env := []string{}
+	for _, level := range levels {
+		if level.envFile != "" {
+			fileEnv, err := utils.ReadEnvFile(level.envFile)
+			if err != nil {
+				return nil, err
+			}
+			env = append(env, fileEnv...)
+		}
+		env = append(env, utils.EnvList(level.env)...)
+	}
+
+	params, err := actionParams(actionData, input)
+	if err != nil {
+		return nil, fmt.Errorf("error with params: %w", err)
+	}
+	paramEnv := make(map[string]string, len(params))
+	for k, v := range params {
+		paramEnv[utils.ParamEnv(k)] = v
+	}
+	env = append(env, utils.EnvList(paramEnv)...)
+
+	return append(env, palEnv(actionData, run, input, req)...), nil
+}
+
+// paramsQuery returns a JSON object input of the params set in query params, empty if none are set
+func paramsQuery(params []data.Param, query url.Values) (string, error) {
+	values := make(map[string]any)
+	for _, p := range params {
+		if !query.Has(p.Name) {
+			continue
+		}
+		v, err := utils.ParamValue(p.Type, query.Get(p.Name))
+		if err != nil {
+			return "", fmt.Errorf("param %s %w", p.Name, err)
+		}
+		values[p.Name] = v
+	}
+
+	if len(values) == 0 {
+		return "", nil
+	}
+
+	b, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+
+	return string(b), nil
+}
+
+// palEnv returns the pal environment variables set for every cmd
+func palEnv(actionData data.ActionData, run data.RunRecord, input, req string) []string {
+	return []string{
+		"PAL_UPLOAD_DIR=" + config.GetConfigStr("http_upload_dir"),
+		"PAL_GROUP=" + actionData.Group,
+		"PAL_ACTION=" + actionData.Action,
+		"PAL_INPUT=" + input,
+		"PAL_REQUEST=" + req,
+		"PAL_PARENT_RUN_ID=" + run.ParentRunID,
+	}
+}
+
+func runBackground(group, action, input string, parent data.RunRecord) {
+	actionData := db.DBC.GetGroupAction(group, action)
+
+	_, err := runAction(actionData, childRun(actionData, parent, input), input, "", nil)
+	if err != nil {
+		logError("", "", err)
+	}
+}
+
+// runAction takes a run slot of the action, waiting for approval and in its queue until done is closed, then runs it
+func runAction(actionData data.ActionData, run data.RunRecord, input, req string, done <-chan struct{}) (data.RunRecord, error) {
+	if err := checkWindows(actionData, time.Now()); err != nil {
+		skipRun(actionData, run, err)
+		run.Status = "skipped"
+		return run, err
+	}
+
+	if actionData.Approval.Required {
+		var err error
+		run, err = awaitApproval(actionData, run, done)
+		if err != nil {
+			return run, err
+		}
+	}
+
+	ready, err := acquire(actionData, run.ID)
+	if err != nil {
+		return run, fmt.Errorf("%w %s/%s", err, actionData.Group, actionData.Action)
+	}
+	if ready != nil && !waitQueue(run, ready, done) {
+		run.Status = "cancelled"
+		return run, errors.New(errorQueueCancelled)
+	}
+
+	// Approval and the queue can wait until after a window closed
+	if err := checkWindows(actionData, time.Now()); err != nil {
+		release(actionData)
+		skipRun(actionData, run, err)
+		run.Status = "skipped"
+		return run, err
+	}
+
+	run, err = execAction(actionData, run, input, req, nil, nil)
+	release(actionData)
+
+	return run, err
+}
+
+// checkWindows returns an error when t is in a blocked window of the action or its group, or outside their
+// allowed windows. Allowed windows of the action replace the ones of its group
+func checkWindows(actionData data.ActionData, t time.Time) error {
+	groupConfig := config.GetConfigGroup(actionData.Group)
+	calendars := config.GetConfigCalendars()
+
+	loc, err := time.LoadLocation(config.GetConfigStr("global_timezone"))
+	if err != nil {
+		loc = time.UTC
+	}
+
+	for _, name := range slices.Concat(groupConfig.BlockedWindows, actionData.BlockedWindows) {
+		if cal, ok := calendars[name]; ok && utils.InCalendar(cal, loc, t) {
+			return fmt.Errorf("error %s/%s is blocked by calendar %s", actionData.Group, actionData.Action, name)
+		}
+	}
+
+	allowed := actionData.AllowedWindows
+	if len(allowed) == 0 {
+		allowed = groupConfig.AllowedWindows
+	}
+	if len(allowed) == 0 {
+		return nil
+	}
+	for _, name := range allowed {
+		if cal, ok := calendars[name]; ok && utils.InCalendar(cal, loc, t) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("error %s/%s is outside of allowed calendars %s", actionData.Group, actionData.Action, strings.Join(allowed, ", "))
+}
+
+// requestApproval saves the run as pending approval and notifies approvers, the channel receives the decision
+func requestApproval(actionData data.ActionData, run data.RunRecord) (data.RunRecord, <-chan db.Approval) {
+	run.Status = "pending"
+	decision := db.PutApproval(run.ID)
+	if err := db.DBC.PutRun(run); err != nil {
+		logError("", "", err)
+	}
+
+	notification := fmt.Sprintf("approval requested for run %s of %s/%s", run.ID, actionData.Group, actionData.Action)
+	if run.RequestedBy != "" {
+		notification += " by " + run.RequestedBy
+	}
+	err := putNotifications(data.Notification{Group: actionData.Group, Action: actionData.Action, Status: "pending", Notification: notification})
+	if err != nil {
+		logError("", "", err)
+	}
+
+	return run, decision
+}
+
+// awaitApproval waits for the decision on a run pending approval, a denied, timed out or cancelled run is saved as rejected
+func awaitApproval(actionData data.ActionData, run data.RunRecord, done <-chan struct{}) (data.RunRecord, error) {
+	// A run requested by RunGroup is already registered and may be decided on already
+	decision, ok := db.GetApproval(run.ID)
+	if !ok {
+		run, decision = requestApproval(actionData, run)
+	}
+
+	timeout := time.Duration(cmp.Or(actionData.Approval.Timeout, approvalTimeout)) * time.Second
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var reason string
+	select {
+	case approval := <-decision:
+		db.DeleteApproval(run.ID)
+		return decideApproval(actionData, run, approval)
+	case <-timer.C:
+		reason = "approval timed out after " + timeout.String()
+	case <-done:
+		reason = "approval cancelled"
+	}
+
+	// A decision made at the same time was already sent to the approver, it wins over the timeout
+	if !db.DeleteApproval(run.ID) {
+		return decideApproval(actionData, run, <-decision)
+	}
+
+	return rejectApproval(actionData, run, reason)
+}
+
+// decideApproval saves the run as approved or rejected by the decision of an approver
+func decideApproval(actionData data.ActionData, run data.RunRecord, approval db.Approval) (data.RunRecord, error) {
+	run.Approver = approval.User
+	if !approval.Approved {
+		return rejectApproval(actionData, run, "denied by "+approval.User)
+	}
+
+	run.Status = "approved"
+	if err := db.DBC.PutRun(run); err != nil {
+		logError("", "", err)
+	}
+
+	return run, nil
+}
+
+// rejectApproval saves the run as rejected with the reason
+func rejectApproval(actionData data.ActionData, run data.RunRecord, reason string) (data.RunRecord, error) {
+	run.Status = "rejected"
+	run.Reason = reason
+	run.Ended = utils.TimeNow(config.GetConfigStr("global_timezone"))
+	if err := db.DBC.PutRun(run); err != nil {
+		logError("", "", err)
+	}
+
+	return run, fmt.Errorf("error run %s of %s/%s rejected %s", run.ID, actionData.Group, actionData.Action, run.Reason)
+}
+
+// RejectPending saves the runs left pending approval by a restart as rejected, nothing is waiting on their decision
+func RejectPending() {
+	for _, run := range db.DBC.GetRuns("", "", "", "pending", time.Time{}) {
+		run.Status = "rejected"
+		run.Reason = "approval pending when pal restarted"
+		run.Ended = utils.TimeNow(config.GetConfigStr("global_timezone"))
+		if err := db.DBC.PutRun(run); err != nil {
+			logError("", "", err)
+		}
+	}
 }
 
-func mergeGroup(action data.ActionData) {
-	action = addRun(action)
-	// Dont use PutGroupAction instead, set Action field in ActionData
-	groupsData := db.DBC.GetGroups()
-	if v, ok := groupsData[action.Group]; ok {
-		for i, e := range v {
-			if e.Action == action.Action {
-				v[i] = action
-				groupsData[action.Group] = v
-				err := db.DBC.PutGroups(groupsData)
-				if err != nil {
-					// TODO: DEBUG STATEMENT
-					log.Println(err.Error())
+// CloseInterrupted saves the runs left running or queued by a restart or crash as errors, their process is gone
+func CloseInterrupted() {
+	ended := utils.TimeNow(config.GetConfigStr("global_timezone"))
+	for _, status := range []string{"running", "queued"} {
+		for _, run := range db.DBC.GetRuns("", "", "", status, time.Time{}) {
+			run.Status = "error"
+			run.Reason = status + " when pal restarted"
+			run.Ended = ended
+			run.QueuePosition, run.QueueLength = 0, 0
+			for i := range run.Steps {
+				if run.Steps[i].Status == "running" || run.Steps[i].Status == "queued" {
+					run.Steps[i].Status = "error"
 				}
-				return
+			}
+			if err := db.DBC.PutRun(run); err != nil {
+				logError("", "", err)
 			}
 		}
 	}
 }
 
-func addRun(action data.ActionData) data.ActionData {
-	run := data.RunHistory{
-		Ran:      action.LastRan,
-		Duration: action.LastDuration,
-		Status:   action.Status,
+// skipTrigger records a triggered run whose when conditions don't hold as skipped in the run history of the action
+func skipTrigger(actionData data.ActionData, parent data.RunRecord, input string, reason error) {
+	skipRun(actionData, childRun(actionData, parent, input), reason)
+}
+
+// skipRun saves a run that didn't run as skipped with the reason and adds it to the run history of the action
+func skipRun(actionData data.ActionData, run data.RunRecord, reason error) {
+	run.Status = "skipped"
+	run.Ended = run.Started
+	run.Duration = utils.FmtDuration(0)
+	run.Reason = reason.Error()
+	if err := db.DBC.PutRun(run); err != nil {
+		logError("", "", err)
 	}
 
-	action.RunHistory = append([]data.RunHistory{run}, action.RunHistory...)
+	groupsMu.Lock()
+	defer groupsMu.Unlock()
+	actionData = db.DBC.GetGroupAction(actionData.Group, actionData.Action)
+	db.DBC.PutGroupAction(actionData.Group, addRun(actionData, data.RunHistory{
+		ID:       run.ID,
+		Ran:      run.Started,
+		Duration: run.Duration,
+		Status:   run.Status,
+	}))
+}
 
-	// more than 5 items, remove the last one the oldest
-	if len(action.RunHistory) > runHistoryLimit {
-		// create a new slice containing all elements except the last one.
-		action.RunHistory = action.RunHistory[:len(action.RunHistory)-1]
+// legacyVars are the $PAL_* variables of templates and the templateData field they're converted to
+var (
+	legacyVarRegex = regexp.MustCompile(`\$PAL_(OUTPUT\.[\w-]+(?:\.[\w-]+)*|OUTPUT_[\w-]+|[A-Z]+(?:_[A-Z]+)*)`)
+	legacyVars     = map[string]string{
+		"GROUP":         "Group",
+		"ACTION":        "Action",
+		"INPUT":         "Input",
+		"STATUS":        "Status",
+		"EXIT_CODE":     "ExitCode",
+		"OUTPUT":        "Output",
+		"STDERR":        "Stderr",
+		"WORKFLOW":      "Workflow",
+		"RUN_ID":        "RunID",
+		"PARENT_RUN_ID": "ParentRunID",
 	}
+)
 
-	return action
+// templateData is the data of notification, webhook body, register and run input templates
+type templateData struct {
+	Group       string
+	Action      string
+	Input       string
+	Status      string
+	ExitCode    int
+	Output      string
+	Stdout      string
+	Stderr      string
+	JSON        any
+	RunID       string
+	ParentRunID string
+	ChainID     string
+	Started     string
+	Ended       string
+	Duration    string
+	Workflow    string
+	Outputs     map[string]string
 }
 
-func putNotifications(notification data.Notification) error {
-	notifications := db.DBC.GetNotifications("", "")
-
-	if len(notifications) >= config.GetConfigInt("notifications_store_max") {
-		notifications = notifications[:len(notifications)-1]
+// newTemplateData returns the template data of a finished run, output is only set when the action has output enabled
+func newTemplateData(actionData data.ActionData, run data.RunRecord) templateData {
+	templateVars := templateData{
+		Group:       actionData.Group,
+		Action:      actionData.Action,
+		Input:       run.Input,
+		Status:      actionData.Status,
+		ExitCode:    run.ExitCode,
+		RunID:       run.ID,
+		ParentRunID: run.ParentRunID,
+		ChainID:     run.ChainID,
+		Started:     run.Started,
+		Ended:       run.Ended,
+		Duration:    run.Duration,
 	}
 
-	notification.ID = uuid.NewString()
-	notification.NotificationRcv = utils.TimeNow(config.GetConfigStr("global_timezone"))
-
-	notifications = append([]data.Notification{notification}, notifications...)
+	if actionData.Output {
+		templateVars.Output = run.Output
+		templateVars.Stdout = run.Stdout
+		templateVars.Stderr = run.Stderr
+		if actionData.OutputFormat == "json" {
+			if err := json.Unmarshal([]byte(run.Stdout), &templateVars.JSON); err != nil {
+				templateVars.JSON = nil
+			}
+		}
+	}
 
-	return db.DBC.PutNotifications(notifications)
+	return templateVars
 }
 
-func sendWebhookNotifications(actionData data.ActionData, output, input string) {
-	webhooks := config.GetConfigWebHooks()
+// renderTemplate replaces the $PAL_* variables of text with the run data, values go through escape when it's set
+// e.g. to keep a JSON webhook body valid. With global.templates text is executed as a text/template, a template
+// that fails is logged and only gets its $PAL_* variables replaced
+func renderTemplate(text string, templateVars templateData, escape func(string) string) string {
+	if !config.GetConfigBool("global_templates") || !strings.Contains(text, "{{") {
+		return legacyTemplate(text, templateVars, escape)
+	}
 
-	var webhookNames []string
-	if actionData.Status == "error" {
-		webhookNames = actionData.OnError.Webhook
-	} else {
-		webhookNames = actionData.OnSuccess.Webhook
+	tmplText := legacyVarRegex.ReplaceAllStringFunc(text, func(v string) string {
+		if action := legacyAction(v, templateVars); action != "" {
+			return action
+		}
+		return v
+	})
+
+	out, err := execTemplate(tmplText, templateVars, escape)
+	if err != nil {
+		logError("", "", err)
+		return legacyTemplate(text, templateVars, escape)
 	}
 
-	// Iterate over each configured webhook
-	for _, webhook := range webhooks {
-		for _, name := range webhookNames {
-			if name == webhook.Name {
-				notification := webhook.Body
-				notification = strings.ReplaceAll(notification, "$PAL_GROUP", actionData.Group)
-				notification = strings.ReplaceAll(notification, "$PAL_ACTION", actionData.Action)
-				notification = strings.ReplaceAll(notification, "$PAL_INPUT", input)
-				notification = strings.ReplaceAll(notification, "$PAL_STATUS", actionData.Status)
-				if actionData.Output {
-					notification = strings.ReplaceAll(notification, "$PAL_OUTPUT", output)
-				}
+	return out
+}
 
-				log.Printf("Sending webhook notification to: %s\n", webhook.Name)
+// legacyTemplate replaces only the $PAL_* variables of text, anything else e.g. {{ }} is left as is
+func legacyTemplate(text string, templateVars templateData, escape func(string) string) string {
+	if !strings.Contains(text, "$PAL_") {
+		return text
+	}
 
-				ctx, cancel := context.WithTimeout(context.Background(), httpClientTimeout*time.Second)
+	return legacyVarRegex.ReplaceAllStringFunc(text, func(v string) string {
+		action := legacyAction(v, templateVars)
+		if action == "" {
+			return v
+		}
+		out, err := execTemplate(action, templateVars, escape)
+		if err != nil {
+			logError("", "", err)
+			return v
+		}
+		return out
+	})
+}
 
-				// Create a new request using the method, URL, and passed-in body
-				req, err := http.NewRequestWithContext(ctx, webhook.Method, webhook.URL, bytes.NewBufferString(notification))
-				if err != nil {
-					log.Printf("Error creating webhook request for %s: %v", webhook.Name, err)
-					cancel()
-					continue
-				}
+// legacyAction returns the template action of a $PAL_* variable, empty when it's not a known variable
+func legacyAction(v string, templateVars templateData) string {
+	name := strings.TrimPrefix(v, "$PAL_")
+	switch {
+	case strings.HasPrefix(name, "OUTPUT.") && templateVars.JSON != nil:
+		return `{{ pal (field "` + strings.TrimPrefix(name, "OUTPUT.") + `") }}`
+	case strings.HasPrefix(name, "OUTPUT."):
+		return "{{ pal .Output }}" + strings.TrimPrefix(name, "OUTPUT")
+	case strings.HasPrefix(name, "OUTPUT_"):
+		return `{{ pal (index .Outputs "` + strings.TrimPrefix(name, "OUTPUT_") + `") }}`
+	}
+	if field, ok := legacyVars[name]; ok {
+		return "{{ pal ." + field + " }}"
+	}
+	return ""
+}
 
-				// Add all configured headers to the request
-				for _, h := range webhook.Headers {
-					req.Header.Set(h.Header, h.Value)
-				}
+// execTemplate executes text as a text/template with the run data
+func execTemplate(text string, templateVars templateData, escape func(string) string) (string, error) {
+	tmpl, err := txttemplate.New("pal").Funcs(templateFuncs(templateVars, escape)).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("error parsing template %w", err)
+	}
 
-				transport := &http2.Transport{
-					TLSClientConfig: &tls.Config{
-						InsecureSkipVerify: webhook.Insecure,
-						MinVersion:         tls.VersionTLS13,
-					},
-				}
+	var out strings.Builder
+	err = tmpl.Execute(&out, templateVars)
+	if err != nil {
+		return "", fmt.Errorf("error executing template %w", err)
+	}
 
-				client := &http.Client{
-					Transport: transport,
-					Timeout:   httpClientTimeout * time.Second,
-				}
+	return out.String(), nil
+}
 
-				// Execute the request
-				resp, err := client.Do(req)
+// templateFuncs returns the functions available in templates, pal and field back the $PAL_* variables
+func templateFuncs(templateVars templateData, escape func(string) string) txttemplate.FuncMap {
+	return txttemplate.FuncMap{
+		"pal": func(v any) string {
+			if escape != nil {
+				return escape(fmt.Sprint(v))
+			}
+			return fmt.Sprint(v)
+		},
+		"field": func(path string) string {
+			value, err := utils.JSONField(templateVars.Stdout, path)
+			if err != nil {
+				return ""
+			}
+			return value
+		},
+		"json": func(v any) (string, error) {
+			out, err := json.Marshal(v)
+			return string(out), err
+		},
+		"shell": func(s string) string {
+			return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+		},
+		"url": url.QueryEscape,
+		"trunc": func(length int, s string) string {
+			if runes := []rune(s); len(runes) > length {
+				return string(runes[:length])
+			}
+			return s
+		},
+		"now": func() time.Time {
+			loc, err := time.LoadLocation(config.GetConfigStr("global_timezone"))
+			if err != nil {
+				return time.Now().UTC()
+			}
+			return time.Now().In(loc)
+		},
+		"date": func(layout string, t any) string {
+			switch v := t.(type) {
+			case time.Time:
+				return v.Format(layout)
+			case string:
+				parsed, err := time.Parse(time.RFC3339, v)
 				if err != nil {
-					log.Printf("Error sending webhook request to %s: %v", webhook.Name, err)
-					cancel()
-					continue
+					return v
 				}
-
-				resp.Body.Close()
-				cancel()
+				return parsed.Format(layout)
 			}
-		}
+			return fmt.Sprint(t)
+		},
+		"db": func(key string) string {
+			dbSet, err := db.DBC.Get(key)
+			if err != nil {
+				return ""
+			}
+			return dbSet.Value
+		},
+		"env": os.Getenv,
 	}
 }
 
-func validateInput(input, inputValidate string) error {
-	return validate.Var(input, inputValidate)
+// jsonEscape escapes a string to be placed inside a JSON string
+func jsonEscape(s string) string {
+	out, err := json.Marshal(s)
+	if err != nil {
+		return s
+	}
+	return string(out[1 : len(out)-1])
 }
 
-func GetRunning(c *echo.Context) error {
-	if !sessionValid(c) && !checkBasicAuth(c) {
-		return c.Redirect(http.StatusSeeOther, "/v1/pal/ui/login")
-	}
-	return c.JSON(http.StatusOK, db.GetRunning())
+// childRun creates the run record of an action triggered by the parent run, one level deeper in the same chain
+func childRun(actionData data.ActionData, parent data.RunRecord, input string) data.RunRecord {
+	run := newRun(actionData, "trigger", input)
+	run.ParentRunID = parent.ID
+	run.ChainID = parent.ChainID
+	run.Depth = parent.Depth + 1
+
+	return run
 }
 
-func requestJSON(c *echo.Context, input string) (string, error) {
-	type RequestData struct {
-		Method      string              `json:"method"`
-		URL         string              `json:"url"`
-		Headers     map[string]string   `json:"headers"`
-		QueryParams map[string][]string `json:"query_params"`
-		Body        string              `json:"body"`
+// newRun creates the run record for an execution, it's stored once the run is queued or started
+func newRun(actionData data.ActionData, trigger, input string) data.RunRecord {
+	id, err := uuid.NewV7()
+	if err != nil {
+		id = uuid.New()
 	}
 
-	requestData := RequestData{
-		Method:      c.Request().Method,
-		URL:         c.Request().URL.String(),
-		Headers:     make(map[string]string),
-		QueryParams: c.QueryParams(),
-		Body:        input,
+	run := data.RunRecord{
+		ID:      id.String(),
+		Group:   actionData.Group,
+		Action:  actionData.Action,
+		Trigger: trigger,
+		Input:   input,
+		Started: utils.TimeNow(config.GetConfigStr("global_timezone")),
+		Status:  "running",
+		ChainID: id.String(),
 	}
 
-	for key, values := range c.Request().Header {
-		requestData.Headers[key] = strings.Join(values, ", ")
-	}
+	return run
+}
 
-	jsonData, err := json.Marshal(requestData)
-	if err != nil {
-		return "", err
+// execAction runs the action cmd and records the result to the run record and action status,
+// then handles register, notifications, webhooks and on_success/on_error triggered actions
+func execAction(actionData data.ActionData, run data.RunRecord, input, req string, stdout, stderr io.Writer) (data.RunRecord, error) {
+	run.Started = utils.TimeNow(config.GetConfigStr("global_timezone"))
+	run.Status = "running"
+	if err := db.DBC.PutRun(run); err != nil {
+		logError("", "", err)
 	}
 
-	return string(jsonData), nil
-}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
 
-func cmdString(actionData data.ActionData, input, req string) string {
-	var cmd string
-	if actionData.Container.Image != "" {
-		containerCmd := config.GetConfigStr("global_container_cmd")
-		envVars := fmt.Sprintf("-e PAL_UPLOAD_DIR='%s' -e PAL_GROUP='%s' -e PAL_ACTION='%s' -e PAL_INPUT='%s' -e PAL_REQUEST='%s'", config.GetConfigStr("http_upload_dir"), actionData.Group, actionData.Action, input, req)
-		cmd = fmt.Sprintf("%s run --rm %s %s %s %s '%s'", containerCmd, envVars, actionData.Container.Options, actionData.Container.Image, config.GetConfigStr("global_cmd_prefix"), actionData.Cmd)
+	db.PutRunning(actionData.Group+"_"+actionData.Action, run.ID, cancel)
+
+	var res data.CmdResult
+	var elapsed time.Duration
+	env, err := cmdEnv(actionData, run, input, req)
+	if err == nil {
+		// Wait for a free global worker, the run can still be cancelled while waiting
+		err = db.AcquireWorker(ctx)
+	}
+	if err == nil {
+		cmdAction := actionData
+		// The container is named after the run so a cancel or timeout can kill it
+		name := "pal-" + run.ID
+		if len(actionData.Args) > 0 {
+			cmdAction.Args = cmdArgs(actionData, name, env)
+		} else {
+			cmdAction.Cmd = cmdString(actionData, name, env)
+		}
+		if actionData.Container.Image != "" {
+			cmdAction.Container.Stop = []string{config.GetConfigStr("global_container_cmd"), "kill", name}
+		}
+		cmdStart := time.Now()
+		res, err = utils.CmdRun(ctx, cmdAction, config.GetConfigStr("global_cmd_prefix"), config.GetConfigStr("global_working_dir"), env, stdout, stderr)
+		elapsed = time.Since(cmdStart)
+		db.ReleaseWorker()
+		if err == nil && actionData.OutputFormat == "json" && !json.Valid([]byte(res.Stdout)) {
+			err = errors.New("error output_format json output is not valid json")
+		}
 	} else {
-		cmdArg := fmt.Sprintf("export PAL_UPLOAD_DIR='%s'; export PAL_GROUP='%s'; export PAL_ACTION='%s'; export PAL_INPUT='%s'; export PAL_REQUEST='%s';", config.GetConfigStr("http_upload_dir"), actionData.Group, actionData.Action, input, req)
-		cmd = strings.Join([]string{cmdArg, actionData.Cmd}, " ")
+		res.ExitCode = utils.ExitCode(err)
 	}
-	// TODO: Add Debug cmd output
-	return cmd
-}
+	db.DeleteRunning(actionData.Group+"_"+actionData.Action, run.ID)
 
-func runBackground(group, action, input string) {
-	actionData := db.DBC.GetGroupAction(group, action)
-	origCmd := actionData.Cmd
-	actionData.Cmd = cmdString(actionData, input, "")
-	db.PutRunning(group + "_" + action)
-	cmdOutput, duration, err := utils.CmdRun(actionData, config.GetConfigStr("global_cmd_prefix"), config.GetConfigStr("global_working_dir"))
-	db.DeleteRunning(group + "_" + action)
-	actionData.Cmd = origCmd
+	cancelled := errors.Is(context.Cause(ctx), db.ErrRunCancelled)
+	if err != nil && cancelled && !errors.Is(err, db.ErrRunCancelled) {
+		err = fmt.Errorf("%w: %w", db.ErrRunCancelled, err)
+	}
+
+	actionData.RunCount++
+	actionData.LastDuration = res.Duration
+	actionData.LastRan = utils.TimeNow(config.GetConfigStr("global_timezone"))
+	actionData.LastRunID = run.ID
+	actionData.LastExitCode = res.ExitCode
+
+	var notification string
+	var triggers []data.Run
 	if err != nil {
-		if !actionData.Concurrent {
-			lock(actionData.Group, actionData.Action, false)
-		}
 		actionData.Status = "error"
-		actionData.RunCount++
-		actionData.LastDuration = duration
-		actionData.LastRan = utils.TimeNow(config.GetConfigStr("global_timezone"))
 		actionData.LastFailure = actionData.LastRan
 		if actionData.Output {
-			actionData.LastFailureOutput = err.Error() + " " + cmdOutput
+			actionData.LastFailureOutput = err.Error()
 		}
-		mergeGroup(actionData)
-		registerActionDB(actionData, actionData.LastFailureOutput, input)
-		logError("", "", err)
-		if actionData.OnError.Notification != "" {
-			notification := actionData.OnError.Notification
-			notification = strings.ReplaceAll(notification, "$PAL_GROUP", actionData.Group)
-			notification = strings.ReplaceAll(notification, "$PAL_ACTION", actionData.Action)
-			notification = strings.ReplaceAll(notification, "$PAL_INPUT", input)
-			notification = strings.ReplaceAll(notification, "$PAL_STATUS", actionData.Status)
-			if actionData.Output {
-				notification = strings.ReplaceAll(notification, "$PAL_OUTPUT", actionData.LastFailureOutput)
-			}
-			err := putNotifications(data.Notification{Group: actionData.Group, Action: actionData.Action, Status: actionData.Status, Notification: notification})
-			if err != nil {
-				logError("", "", err)
-			}
+		notification = actionData.OnError.Notification
+		// Cancelled runs notify through on_error but don't chain on_error runs
+		if cancelled {
+			actionData.Status = "cancelled"
+		} else {
+			triggers = actionData.OnError.Run
 		}
-		sendWebhookNotifications(actionData, actionData.LastFailureOutput, input)
-		for _, e := range actionData.OnError.Run {
-			runAction := db.DBC.GetGroupAction(e.Group, e.Action)
-			if !runAction.Disabled {
-				go func() {
-					errorGroup := e.Group
-					errorAction := e.Action
-					errorInput := e.Input
-					errorInput = strings.ReplaceAll(errorInput, "$PAL_GROUP", actionData.Group)
-					errorInput = strings.ReplaceAll(errorInput, "$PAL_ACTION", actionData.Action)
-					if actionData.Output {
-						errorInput = strings.ReplaceAll(errorInput, "$PAL_OUTPUT", actionData.LastFailureOutput)
-					}
-					errorInput = strings.ReplaceAll(errorInput, "$PAL_STATUS", actionData.Status)
-					runBackground(errorGroup, errorAction, errorInput)
-				}()
-			}
+	} else {
+		actionData.Status = "success"
+		actionData.LastSuccess = actionData.LastRan
+		if actionData.Output {
+			actionData.LastSuccessOutput = res.Stdout
 		}
-		return
+		notification = actionData.OnSuccess.Notification
+		triggers = actionData.OnSuccess.Run
 	}
-	actionData.Status = "success"
-	actionData.RunCount++
-	actionData.LastDuration = duration
-	actionData.LastRan = utils.TimeNow(config.GetConfigStr("global_timezone"))
-	actionData.LastSuccess = actionData.LastRan
+
+	run.Ended = actionData.LastRan
+	run.Duration = res.Duration
+	run.ExitCode = res.ExitCode
+	run.Status = actionData.Status
+	run.Attempts = res.Attempts
 	if actionData.Output {
-		actionData.LastSuccessOutput = cmdOutput
+		run.Output = utils.GetLastOutput(actionData)
+		run.Stdout = res.Stdout
+		run.Stderr = res.Stderr
+	} else {
+		for i := range run.Attempts {
+			run.Attempts[i].Stdout, run.Attempts[i].Stderr = "", ""
+		}
+	}
+	if putErr := db.DBC.PutRun(run); putErr != nil {
+		logError("", "", putErr)
 	}
+
 	mergeGroup(actionData)
-	registerActionDB(actionData, actionData.LastSuccessOutput, input)
-	if actionData.OnSuccess.Notification != "" {
-		notification := actionData.OnSuccess.Notification
-		notification = strings.ReplaceAll(notification, "$PAL_GROUP", actionData.Group)
-		notification = strings.ReplaceAll(notification, "$PAL_ACTION", actionData.Action)
-		notification = strings.ReplaceAll(notification, "$PAL_INPUT", input)
-		notification = strings.ReplaceAll(notification, "$PAL_STATUS", actionData.Status)
-		if actionData.Output {
-			notification = strings.ReplaceAll(notification, "$PAL_OUTPUT", cmdOutput)
-		}
-		err := putNotifications(data.Notification{Group: group, Action: actionData.Action, Status: actionData.Status, Notification: notification})
-		if err != nil {
-			logError("", "", err)
+	registerActionDB(actionData, run)
+
+	templateVars := newTemplateData(actionData, run)
+	if notification != "" {
+		notification = renderTemplate(notification, templateVars, nil)
+		notifyErr := putNotifications(data.Notification{Group: actionData.Group, Action: actionData.Action, Status: actionData.Status, Notification: notification})
+		if notifyErr != nil {
+			logError("", "", notifyErr)
 		}
 	}
-	sendWebhookNotifications(actionData, actionData.LastSuccessOutput, input)
-	for _, e := range actionData.OnSuccess.Run {
+
+	sendWebhookNotifications(actionData, run)
+
+	for _, e := range triggers {
 		runAction := db.DBC.GetGroupAction(e.Group, e.Action)
 		if !runAction.Disabled {
 			go func() {
-				successGroup := e.Group
-				successAction := e.Action
-				successInput := e.Input
-				successInput = strings.ReplaceAll(successInput, "$PAL_GROUP", actionData.Group)
-				successInput = strings.ReplaceAll(successInput, "$PAL_ACTION", actionData.Action)
-				if actionData.Output {
-					successInput = strings.ReplaceAll(successInput, "$PAL_OUTPUT", cmdOutput)
+				triggerInput := renderTemplate(e.Input, templateVars, nil)
+				// Skip the triggered run if its when conditions don't hold for this run or the chain is too deep
+				if err := utils.When(e.When, res.Stdout, elapsed); err != nil {
+					skipTrigger(runAction, run, triggerInput, err)
+					return
+				}
+				if maxDepth := config.GetConfigInt("global_max_chain_depth"); run.Depth >= maxDepth {
+					err := fmt.Errorf("error max chain depth %d reached by %s/%s in chain %s", maxDepth, e.Group, e.Action, run.ChainID)
+					logError("", "", err)
+					skipTrigger(runAction, run, triggerInput, err)
+					return
 				}
-				successInput = strings.ReplaceAll(successInput, "$PAL_STATUS", actionData.Status)
-				runBackground(successGroup, successAction, successInput)
+				runBackground(e.Group, e.Action, triggerInput, run)
 			}()
 		}
 	}
 
-	if !actionData.Concurrent {
-		lock(actionData.Group, actionData.Action, false)
+	return run, err
+}
+
+// newWorkflowRun creates the run record of a workflow with every step pending
+func newWorkflowRun(workflow data.Workflow, trigger, input string) data.RunRecord {
+	run := newRun(data.ActionData{}, trigger, input)
+	run.Workflow = workflow.Name
+	for _, step := range workflow.Steps {
+		run.Steps = append(run.Steps, data.StepRun{
+			Name:   step.Name,
+			Group:  step.Group,
+			Action: step.Action,
+			Needs:  step.Needs,
+			Status: "pending",
+		})
+	}
+
+	return run
+}
+
+// runWorkflow starts each step once the steps it needs are done, steps without needs between them run in parallel.
+// The workflow run record is updated as steps change status and it's an error if any step errored or was cancelled
+func runWorkflow(workflow data.Workflow, run data.RunRecord, input string) data.RunRecord {
+	started := time.Now()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	db.PutRunning("workflow_"+workflow.Name, run.ID, cancel)
+	defer db.DeleteRunning("workflow_"+workflow.Name, run.ID)
+
+	var mu sync.Mutex
+	putRun := func() {
+		if err := db.DBC.PutRun(run); err != nil {
+			logError("", "", err)
+		}
+	}
+
+	run.Status = "running"
+	putRun()
+
+	done := make(map[string]chan struct{})
+	for _, step := range workflow.Steps {
+		done[step.Name] = make(chan struct{})
+	}
+	outputs := make(map[string]string)
+
+	var wg sync.WaitGroup
+	for i, step := range workflow.Steps {
+		wg.Go(func() {
+			defer close(done[step.Name])
+			for _, need := range step.Needs {
+				<-done[need]
+			}
+
+			mu.Lock()
+			if status := skipStep(ctx, step, run.Steps); status != "" {
+				run.Steps[i].Status = status
+				putRun()
+				mu.Unlock()
+				return
+			}
+			stepInput := workflowInput(workflow, step, input, outputs)
+			mu.Unlock()
+
+			actionData := db.DBC.GetGroupAction(step.Group, step.Action)
+			stepRun := newRun(actionData, "workflow", stepInput)
+
+			mu.Lock()
+			run.Steps[i].Status = "running"
+			run.Steps[i].RunID = stepRun.ID
+			run.Steps[i].Started = stepRun.Started
+			putRun()
+			mu.Unlock()
+
+			var err error
+			if actionData.Action == "" || actionData.Disabled {
+				err = fmt.Errorf("error workflow %s step %s action %s/%s is missing or disabled", workflow.Name, step.Name, step.Group, step.Action)
+				stepRun.Status = "error"
+			} else {
+				// Cancelling the workflow cancels the running step, a queued step leaves the queue through done
+				stop := context.AfterFunc(ctx, func() { db.CancelRunning(stepRun.ID) })
+				stepRun, err = runAction(actionData, stepRun, stepInput, "", ctx.Done())
+				stop()
+			}
+			if err != nil {
+				logError("", "", err)
+				if stepRun.Status != "cancelled" {
+					stepRun.Status = "error"
+				}
+			}
+
+			mu.Lock()
+			run.Steps[i].Status = stepRun.Status
+			run.Steps[i].Ended = utils.TimeNow(config.GetConfigStr("global_timezone"))
+			run.Steps[i].Duration = stepRun.Duration
+			run.Steps[i].ExitCode = stepRun.ExitCode
+			if actionData.Output {
+				outputs[step.Name] = stepRun.Stdout
+			}
+			putRun()
+			mu.Unlock()
+		})
+	}
+	wg.Wait()
+
+	run.Status = "success"
+	for _, e := range run.Steps {
+		if e.Status == "error" || e.Status == "cancelled" {
+			if run.Status == "success" {
+				run.ExitCode = e.ExitCode
+			}
+			run.Status = "error"
+		}
+	}
+	if errors.Is(context.Cause(ctx), db.ErrRunCancelled) {
+		run.Status = "cancelled"
+	}
+	run.Ended = utils.TimeNow(config.GetConfigStr("global_timezone"))
+	run.Duration = utils.FmtDuration(int(time.Since(started).Seconds()))
+	putRun()
+
+	return run
+}
+
+// skipStep returns the status of a step that doesn't run from the status of the steps it needs, empty if it runs.
+// if success needs every step it needs to succeed, failure needs one to error or be cancelled and always runs regardless
+func skipStep(ctx context.Context, step data.WorkflowStep, steps []data.StepRun) string {
+	if ctx.Err() != nil {
+		return "cancelled"
+	}
+
+	var failed, skipped bool
+	for _, e := range steps {
+		if !slices.Contains(step.Needs, e.Name) {
+			continue
+		}
+		switch e.Status {
+		case "error", "cancelled":
+			failed = true
+		case "skipped":
+			skipped = true
+		}
+	}
+
+	switch step.If {
+	case "always":
+		return ""
+	case "failure":
+		if failed {
+			return ""
+		}
+	default:
+		if !failed && !skipped {
+			return ""
+		}
+	}
+
+	return "skipped"
+}
+
+// workflowInput renders the input of a step, outputs are the stdout of finished steps whose action has output enabled
+func workflowInput(workflow data.Workflow, step data.WorkflowStep, input string, outputs map[string]string) string {
+	templateVars := templateData{
+		Workflow: workflow.Name,
+		Input:    input,
+		Outputs:  make(map[string]string),
 	}
+	for _, need := range step.Needs {
+		templateVars.Outputs[need] = strings.TrimSpace(outputs[need])
+	}
+
+	return renderTemplate(step.Input, templateVars, nil)
 }
 
 func ReloadActions(groups map[string][]data.ActionData) error {
+	if err := config.ValidateTriggers(groups); err != nil {
+		return err
+	}
+
 	actionIndex := make(map[string]map[string]*data.ActionData)
 	for groupName, actions := range groups {
 		actionIndex[groupName] = make(map[string]*data.ActionData)
@@ -2230,6 +4292,7 @@ func ReloadActions(groups map[string][]data.ActionData) error {
 				TriggerAction:    rule.Action,
 				TriggerCondition: condition,
 				TriggerInput:     rule.Input,
+				TriggerWhen:      rule.When,
 			}
 
 			originAction.Triggers = append(originAction.Triggers, trigger)
@@ -2245,5 +4308,8 @@ func ReloadActions(groups map[string][]data.ActionData) error {
 	}
 
 	mergedGroups := utils.MergeGroups(db.DBC.GetGroups(), groups)
+	// Compile the params the stored actions validate against
+	compileParams(mergedGroups)
+
 	return db.DBC.PutGroups(mergedGroups)
 }
@@ -6,10 +6,18 @@ HEADER='X-Pal-Auth: PaLLy!@#890-'
 BASIC_AUTH='pal:p@LLy5'
 COOKIE_FILE="./pal.cookie"
 TEST_FILE="./test.txt"
+PAL_BIN=""
+PAL_CONFIG=""
+MISFIRE_DIR=""
+MISFIRE_PID=""
+LEADER_PID=""
 
 cleanup() {
     echo "Cleaning up temporary files..."
     rm -f "$COOKIE_FILE" "$TEST_FILE"
+    [ -n "$MISFIRE_PID" ] && kill "$MISFIRE_PID" 2>/dev/null
+    [ -n "$LEADER_PID" ] && kill "$LEADER_PID" 2>/dev/null
+    [ -n "$MISFIRE_DIR" ] && rm -rf "$MISFIRE_DIR"
 }
 
 # Trap signals: runs cleanup on exit (success or fail) and interrupts (SIGINT/SIGTERM)
@@ -28,6 +36,8 @@ while [ $# -gt 0 ]; do
     -host) HOST="$2"; shift 2 ;;
     -header) HEADER="$2"; shift 2 ;;
     -basicauth) BASIC_AUTH="$2"; shift 2 ;;
+    -bin) PAL_BIN="$2"; shift 2 ;;
+    -config) PAL_CONFIG="$2"; shift 2 ;;
     *) echo "Unknown option: $1" >&2; exit 1 ;;
     esac
 done
@@ -129,6 +139,40 @@ else
     echo "[fail] block" && exit 1
 fi
 
+# not_ready
+curl -sSk -H "$HEADER" "$URL/v1/pal/run/test/not_ready?input=2" >/dev/null &
+sleep 1
+OUT=$(curl -sSk -H "$HEADER" "$URL/v1/pal/run/test/not_ready?input=1")
+if contains "$OUT" "error not ready"; then
+    echo "[pass] not_ready"
+else
+    echo "$OUT"
+    echo "[fail] not_ready" && exit 1
+fi
+
+# queue
+curl -sSk -H "$HEADER" "$URL/v1/pal/run/test/queue?input=2" >/dev/null &
+sleep 1
+OUT=$(curl -sSk -H "$HEADER" -D - "$URL/v1/pal/run/test/queue?input=1")
+if contains "$OUT" "1 queue" && (contains "$OUT" "X-Pal-Queue-Position: 1" || contains "$OUT" "x-pal-queue-position: 1"); then
+    echo "[pass] queue"
+else
+    echo "$OUT"
+    echo "[fail] queue" && exit 1
+fi
+
+# max_concurrent
+curl -sSk -H "$HEADER" "$URL/v1/pal/run/test/max_concurrent?input=3" >/dev/null &
+curl -sSk -H "$HEADER" "$URL/v1/pal/run/test/max_concurrent?input=3" >/dev/null &
+sleep 1
+OUT=$(curl -sSk -H "$HEADER" "$URL/v1/pal/run/test/max_concurrent?input=1")
+if contains "$OUT" "error not ready"; then
+    echo "[pass] max_concurrent"
+else
+    echo "$OUT"
+    echo "[fail] max_concurrent" && exit 1
+fi
+
 # no_block
 curl -sSk -H "$HEADER" "$URL/v1/pal/run/test/no_block" >/dev/null &
 sleep 1
@@ -167,6 +211,339 @@ else
     echo "[fail] retry_fail" && exit 1
 fi
 
+# retry_on
+OUT=$(curl -sSk -H "$HEADER" "$URL/v1/pal/run/test/retry_on?input=1")
+if contains "$OUT" "0 retries"; then
+    echo "[pass] retry_on"
+else
+    echo "$OUT"
+    echo "[fail] retry_on" && exit 1
+fi
+
+OUT=$(curl -sSk -H "$HEADER" "$URL/v1/pal/run/test/retry_on?input=75")
+if contains "$OUT" "3 retries"; then
+    echo "[pass] retry_on_match"
+else
+    echo "$OUT"
+    echo "[fail] retry_on_match" && exit 1
+fi
+
+# env
+OUT=$(curl -sSk -H "$HEADER" "$URL/v1/pal/run/test/env?input=it%27s%20%24HOME")
+if contains "$OUT" "it's \$HOME env value"; then
+    echo "[pass] env"
+else
+    echo "$OUT"
+    echo "[fail] env" && exit 1
+fi
+
+# args
+OUT=$(curl -sSk -H "$HEADER" "$URL/v1/pal/run/test/args?input=%27a%20b%27%3B%20echo%20%24HOME")
+if contains "$OUT" "'a b'; echo \$HOME|args"; then
+    echo "[pass] args"
+else
+    echo "$OUT"
+    echo "[fail] args" && exit 1
+fi
+
+# args_prefix
+OUT=$(curl -sSk "$URL/v1/pal/run/test/args_prefix?env=dev&environment=staging")
+if contains "$OUT" "dev|staging"; then
+    echo "[pass] args_prefix"
+else
+    echo "$OUT"
+    echo "[fail] args_prefix" && exit 1
+fi
+
+# workflows
+OUT=$(curl -sSk -H "$HEADER" "$URL/v1/pal/workflows/pipeline/run?input=hello")
+if contains "$OUT" '"workflow":"pipeline"' && contains "$OUT" '"status":"error"' &&
+    contains "$OUT" '"name":"skipped","group":"test","action":"workflow_echo","needs":["fail"],"status":"skipped"' &&
+    contains "$OUT" '"name":"fanin","group":"test","action":"workflow_echo","needs":["fanout","recover"],"status":"success"'; then
+    echo "[pass] workflows"
+else
+    echo "$OUT"
+    echo "[fail] workflows" && exit 1
+fi
+
+# watch
+curl -sSk -XPOST -F files="@$TEST_FILE;filename=test.watch" -b "$COOKIE_FILE" "$URL/v1/pal/ui/files/upload" >/dev/null
+sleep 1
+OUT=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/runs?group=test&action=watch")
+if contains "$OUT" '"trigger":"watch"' && contains "$OUT" 'watched test.watch'; then
+    echo "[pass] watch"
+else
+    echo "$OUT"
+    echo "[fail] watch" && exit 1
+fi
+curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/ui/files/delete/test.watch" >/dev/null
+
+# when
+curl -sSk "$URL/v1/pal/run/test/when" >/dev/null
+sleep 1
+OUT=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/runs?group=test&action=workflow_echo")
+if contains "$OUT" '"input":"when_json","started"' &&
+    contains "$OUT" '"status":"skipped"' &&
+    contains "$OUT" '"reason":"output does not match ^not_ready$"' &&
+    contains "$OUT" '"reason":"duration 0s is under min_duration 30s"'; then
+    echo "[pass] when"
+else
+    echo "$OUT"
+    echo "[fail] when" && exit 1
+fi
+
+# chain depth
+curl -sSk "$URL/v1/pal/run/test/chain" >/dev/null
+sleep 2
+OUT=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/runs?group=test&action=chain&status=skipped")
+if contains "$OUT" '"reason":"error max chain depth'; then
+    echo "[pass] chain_depth"
+else
+    echo "$OUT"
+    echo "[fail] chain_depth" && exit 1
+fi
+
+# output_format json
+RUN_ID=$(curl -sSk -D - -o /dev/null "$URL/v1/pal/run/test/json_output" | grep -i "x-pal-run-id" | awk '{print $2}' | tr -d '\r')
+sleep 1
+OUT=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/runs?group=test&action=json_child")
+if contains "$OUT" "version=1.2.3 artifact=app.tar parent=$RUN_ID"; then
+    echo "[pass] output_format_json"
+else
+    echo "$OUT"
+    echo "[fail] output_format_json" && exit 1
+fi
+
+OUT=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/db/get?key=json_output%201.2.3")
+if contains "$OUT" "app.tar"; then
+    echo "[pass] output_format_json_register"
+else
+    echo "$OUT"
+    echo "[fail] output_format_json_register" && exit 1
+fi
+
+# auth_hmac
+BODY='{"ref":"main"}'
+SIG=$(printf '%s' "$BODY" | openssl dgst -sha256 -hmac github_secret | cut -d' ' -f2)
+OUT=$(curl -sSk -XPOST -H "X-Hub-Signature-256: sha256=$SIG" -d "$BODY" "$URL/v1/pal/run/test/github")
+BAD=$(curl -sSk -XPOST -H "X-Hub-Signature-256: sha256=$SIG" -d '{"ref":"evil"}' "$URL/v1/pal/run/test/github")
+if contains "$OUT" '{"ref":"main"}' && contains "$BAD" "error unauthorized"; then
+    echo "[pass] auth_hmac"
+else
+    echo "$OUT $BAD"
+    echo "[fail] auth_hmac" && exit 1
+fi
+
+# auth_hmac_timestamp
+curl -sSk -XPUT -b "$COOKIE_FILE" -d 'slack_secret' "$URL/v1/pal/db/put?key=slack_secret" >/dev/null
+TS=$(date +%s)
+SIG=$(printf 'v0:%s:%s' "$TS" "$BODY" | openssl dgst -sha256 -hmac slack_secret | cut -d' ' -f2)
+OUT=$(curl -sSk -XPOST -H "X-Slack-Signature: v0=$SIG" -H "X-Slack-Request-Timestamp: $TS" -d "$BODY" "$URL/v1/pal/run/test/slack")
+OLD=$((TS - 600))
+SIG=$(printf 'v0:%s:%s' "$OLD" "$BODY" | openssl dgst -sha256 -hmac slack_secret | cut -d' ' -f2)
+BAD=$(curl -sSk -XPOST -H "X-Slack-Signature: v0=$SIG" -H "X-Slack-Request-Timestamp: $OLD" -d "$BODY" "$URL/v1/pal/run/test/slack")
+if contains "$OUT" '{"ref":"main"}' && contains "$BAD" "error unauthorized"; then
+    echo "[pass] auth_hmac_timestamp"
+else
+    echo "$OUT $BAD"
+    echo "[fail] auth_hmac_timestamp" && exit 1
+fi
+
+# params
+OUT=$(curl -sSk "$URL/v1/pal/run/test/params?env=dev&tag=v1.2")
+POST=$(curl -sSk -XPOST -d '{"env":"prod","replicas":5,"dry-run":true}' "$URL/v1/pal/run/test/params")
+BAD=$(curl -sSk -XPOST -d '{"env":"qa","replicas":1.5}' "$URL/v1/pal/run/test/params")
+if contains "$OUT" "env=dev replicas=2 dry=false tag=v1.2" &&
+    contains "$POST" "env=prod replicas=5 dry=true tag=" &&
+    contains "$BAD" "value must be one of 'dev', 'prod'" &&
+    contains "$BAD" "got number, want integer"; then
+    echo "[pass] params"
+else
+    echo "$OUT $POST $BAD"
+    echo "[fail] params" && exit 1
+fi
+
+OUT=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/action?group=test&action=params")
+if contains "$OUT" '"required": [' && contains "$OUT" '"pattern": "^v[0-9.]+$"'; then
+    echo "[pass] params_schema"
+else
+    echo "$OUT"
+    echo "[fail] params_schema" && exit 1
+fi
+
+# approval
+RUN_ID=$(curl -sSk -D - -o /dev/null "$URL/v1/pal/run/test/approval?input=ok" | grep -i 'x-pal-run-id' | cut -d' ' -f2 | tr -d '\r')
+PENDING=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/runs/$RUN_ID")
+APPROVE=$(curl -sSk -XPOST -b "$COOKIE_FILE" "$URL/v1/pal/runs/$RUN_ID/approve")
+sleep 1
+OUT=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/runs/$RUN_ID")
+if contains "$PENDING" '"status":"pending"' && contains "$APPROVE" "approved run" &&
+    contains "$OUT" '"status":"success"' && contains "$OUT" '"approver":"pal"' && contains "$OUT" 'approved ok'; then
+    echo "[pass] approval"
+else
+    echo "$PENDING $APPROVE $OUT"
+    echo "[fail] approval" && exit 1
+fi
+
+RUN_ID=$(curl -sSk -D - -o /dev/null "$URL/v1/pal/run/test/approval" | grep -i 'x-pal-run-id' | cut -d' ' -f2 | tr -d '\r')
+curl -sSk -XPOST -b "$COOKIE_FILE" "$URL/v1/pal/runs/$RUN_ID/deny" >/dev/null
+sleep 1
+OUT=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/runs/$RUN_ID")
+RUN_ID=$(curl -sSk -D - -o /dev/null "$URL/v1/pal/run/test/approval_timeout" | grep -i 'x-pal-run-id' | cut -d' ' -f2 | tr -d '\r')
+sleep 2
+TIMEOUT=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/runs/$RUN_ID")
+NOTIFY=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/notifications?group=test")
+if contains "$OUT" '"status":"rejected"' && contains "$OUT" '"reason":"denied by pal"' &&
+    contains "$TIMEOUT" '"reason":"approval timed out after 1s"' &&
+    contains "$NOTIFY" "approval requested for run $RUN_ID of test/approval_timeout"; then
+    echo "[pass] approval_rejected"
+else
+    echo "$OUT $TIMEOUT"
+    echo "[fail] approval_rejected" && exit 1
+fi
+
+# one_off
+OUT=$(curl -sSk -XPOST -d 'later' "$URL/v1/pal/run/test/one_off?delay=1s")
+sleep 2
+RUNS=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/runs?group=test&action=one_off")
+if contains "$OUT" '"input":"later"' && contains "$RUNS" '"trigger":"schedule"' && contains "$RUNS" 'one_off later'; then
+    echo "[pass] one_off"
+else
+    echo "$OUT $RUNS"
+    echo "[fail] one_off" && exit 1
+fi
+
+ID=$(curl -sSk "$URL/v1/pal/run/test/one_off?input=cancel&at=2099-01-01T00:00:00Z" | sed 's/.*"id":"\([^"]*\)".*/\1/')
+LIST=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/schedules")
+DEL=$(curl -sSk -XDELETE -b "$COOKIE_FILE" "$URL/v1/pal/schedules/$ID")
+AFTER=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/schedules")
+if contains "$LIST" "\"id\":\"$ID\",\"input\":\"cancel\",\"paused\":false,\"one_off\":true" &&
+    contains "$DEL" "cancelled one-off schedule $ID" && ! contains "$AFTER" "$ID"; then
+    echo "[pass] one_off_cancel"
+else
+    echo "$LIST $DEL $AFTER"
+    echo "[fail] one_off_cancel" && exit 1
+fi
+
+# schedule_object
+SCHED=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/schedules?group=test&action=schedule_object&run=now")
+sleep 2
+RUNS=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/runs?group=test&action=schedule_object")
+if contains "$SCHED" '"running"' && contains "$RUNS" '"trigger":"schedule"' && contains "$RUNS" 'schedule_object weekday'; then
+    echo "[pass] schedule_object"
+else
+    echo "$SCHED $RUNS"
+    echo "[fail] schedule_object" && exit 1
+fi
+
+# schedules/pause
+ID=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/schedules" | sed 's/.*"action":"schedule_seconds","id":"\([^"]*\)".*/\1/')
+PAUSE=$(curl -sSk -XPOST -b "$COOKIE_FILE" "$URL/v1/pal/schedules/pause?id=$ID")
+PAUSED=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/schedules")
+RESUME=$(curl -sSk -XPOST -b "$COOKIE_FILE" "$URL/v1/pal/schedules/resume?id=$ID")
+curl -sSk -XPOST -b "$COOKIE_FILE" "$URL/v1/pal/schedules/pause?group=test" >/dev/null
+GROUP=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/schedules")
+curl -sSk -XPOST -b "$COOKIE_FILE" "$URL/v1/pal/schedules/resume?group=test" >/dev/null
+RESUMED=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/schedules")
+if contains "$PAUSE" "paused schedule $ID" && contains "$PAUSED" "\"id\":\"$ID\",\"cron\":\"30 0 5 * * 0\",\"paused\":true" &&
+    contains "$RESUME" "resumed schedule $ID" && contains "$GROUP" "\"id\":\"$ID\",\"cron\":\"30 0 5 * * 0\",\"paused\":true" &&
+    contains "$RESUMED" "\"id\":\"$ID\",\"cron\":\"30 0 5 * * 0\",\"paused\":false"; then
+    echo "[pass] schedules/pause"
+else
+    echo "$PAUSE $PAUSED $RESUME $GROUP $RESUMED"
+    echo "[fail] schedules/pause" && exit 1
+fi
+
+# schedules/preview
+JOB=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/schedules/preview?id=$ID&count=2")
+CRON=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/schedules/preview?cron=*/10%20*%20*%20*%20*%20*&seconds=true&timezone=UTC&count=3")
+if contains "$JOB" '"action":"schedule_seconds","cron":"30 0 5 * * 0","seconds":true' && contains "$JOB" 'T05:00:30' &&
+    [ "$(echo "$CRON" | grep -o 'Z"' | wc -l)" -eq 3 ]; then
+    echo "[pass] schedules/preview"
+else
+    echo "$JOB $CRON"
+    echo "[fail] schedules/preview" && exit 1
+fi
+
+# reload
+OUT=$(curl -sSk -XPOST -b "$COOKIE_FILE" "$URL/v1/pal/actions/reload")
+if contains "$OUT" '{"added":[],"removed":[],"updated":[]}'; then
+    echo "[pass] reload"
+else
+    echo "$OUT"
+    echo "[fail] reload" && exit 1
+fi
+
+# windows
+FREEZE=$(curl -sSk -w ' %{http_code}' "$URL/v1/pal/run/windows/freeze")
+HOLIDAY=$(curl -sSk -w ' %{http_code}' "$URL/v1/pal/run/windows/holiday")
+OUTSIDE=$(curl -sSk -w ' %{http_code}' "$URL/v1/pal/run/windows/outside")
+ALLOWED=$(curl -sSk "$URL/v1/pal/run/windows/allowed")
+if contains "$FREEZE" 'blocked by calendar freeze 423' && contains "$HOLIDAY" 'blocked by calendar holidays 423' &&
+    contains "$OUTSIDE" 'outside of allowed calendars past 423' && contains "$ALLOWED" 'allowed'; then
+    echo "[pass] windows"
+else
+    echo "$FREEZE $HOLIDAY $OUTSIDE $ALLOWED"
+    echo "[fail] windows" && exit 1
+fi
+
+curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/schedules?group=windows&action=freeze&run=now" >/dev/null
+sleep 1
+RUNS=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/runs?group=windows&action=freeze")
+if contains "$RUNS" '"status":"skipped"' && contains "$RUNS" 'blocked by calendar freeze'; then
+    echo "[pass] windows_schedule"
+else
+    echo "$RUNS"
+    echo "[fail] windows_schedule" && exit 1
+fi
+
+# templates
+curl -sSk -XPUT -b "$COOKIE_FILE" -d 'seeded' "$URL/v1/pal/db/put?key=template_seed" >/dev/null
+curl -sSk "$URL/v1/pal/run/test/template?input=it%27s" >/dev/null
+sleep 1
+OUT=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/db/get?key=template_template")
+if contains "$OUT" "2.0 'it'\\''s' tem seeded"; then
+    echo "[pass] templates"
+else
+    echo "$OUT"
+    echo "[fail] templates" && exit 1
+fi
+
+curl -sSk "$URL/v1/pal/run/test/template_fallback" >/dev/null
+OUT=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/db/get?key=template_template_fallback")
+if contains "$OUT" "template_fallback {{ .Nope"; then
+    echo "[pass] templates_fallback"
+else
+    echo "$OUT"
+    echo "[fail] templates_fallback" && exit 1
+fi
+
+OUT=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/notifications?group=test")
+if contains "$OUT" 'test/template INPUT=it'; then
+    echo "[pass] templates_webhook_json"
+else
+    echo "$OUT"
+    echo "[fail] templates_webhook_json" && exit 1
+fi
+
+# exit_codes
+OUT=$(curl -sSk -H "$HEADER" -D - "$URL/v1/pal/run/test/exit_codes?input=123")
+if contains "$OUT" "X-Pal-Exit-Code: 1" || contains "$OUT" "x-pal-exit-code: 1"; then
+    echo "[pass] exit_codes"
+else
+    echo "$OUT"
+    echo "[fail] exit_codes" && exit 1
+fi
+
+# exit_codes/stream
+OUT=$(curl -sSNk -H "$HEADER" -D - "$URL/v1/pal/run/test/exit_codes?input=123&stream=true")
+if contains "$OUT" '{"status":"success","exit_code":1}' && ! contains "$OUT" "X-Pal-Exit-Code" && ! contains "$OUT" "x-pal-exit-code"; then
+    echo "[pass] exit_codes_stream"
+else
+    echo "$OUT"
+    echo "[fail] exit_codes_stream" && exit 1
+fi
+
 # json/parse
 OUT=$(curl -sSk -H "$HEADER" "$URL/v1/pal/run/json/parse?input=%7B%22hello%22%3A%22world%22%7D")
 if contains "$OUT" "hello"; then
@@ -212,6 +589,27 @@ else
     echo "[fail] db/register" && exit 1
 fi
 
+OUT=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/db/get?key=exit_codes")
+if contains "$OUT" "exit_code=1 stderr=exit_codes stderr"; then
+    echo "[pass] db/register_stderr"
+else
+    echo "$OUT"
+    echo "[fail] db/register_stderr" && exit 1
+fi
+
+# runs/cancel
+RUN_ID=$(curl -sSk -H "$HEADER" -D - -o /dev/null "$URL/v1/pal/run/test/cancel" | grep -i "x-pal-run-id" | awk '{print $2}' | tr -d '\r')
+sleep 1
+curl -sSk -XDELETE -b "$COOKIE_FILE" "$URL/v1/pal/runs/$RUN_ID" >/dev/null
+sleep 1
+OUT=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/runs/$RUN_ID")
+if contains "$OUT" '"status":"cancelled"'; then
+    echo "[pass] runs/cancel"
+else
+    echo "$OUT"
+    echo "[fail] runs/cancel" && exit 1
+fi
+
 # DB Delete
 curl -sfk -XDELETE -b "$COOKIE_FILE" "$URL/v1/pal/db/delete?key=test" >/dev/null
 OUT=$(curl -sSk -b "$COOKIE_FILE" "$URL/v1/pal/db/get?key=test")
@@ -250,4 +648,349 @@ if contains "$OUT" "WEBHOOK"; then
 else
     echo "$OUT"
     echo "[fail] notifications/webhook" && exit 1
-fi
\ No newline at end of file
+fi
+
+# misfire: start a second pal on its own port, DB and actions, stop it after its schedule fired and restart it after downtime
+if [ -n "$PAL_BIN" ] && [ -n "$PAL_CONFIG" ]; then
+    MISFIRE_PORT=$((PORT + 1))
+    MISFIRE_DIR=$(mktemp -d)
+    mkdir "$MISFIRE_DIR/actions"
+    sed -e "s|^  listen: .*|  listen: $HOST:$MISFIRE_PORT|" -e "s|^  path: .*|  path: \"$MISFIRE_DIR/pal.db\"|" \
+        -e "s|^  type: .*|  type: file|" -e "s|^  lease_file: .*|  lease_file: $MISFIRE_DIR/pal.lease|" \
+        -e "s|^  node: .*|  node: pal-a|" -e "s|^  ttl: .*|  ttl: 3|" "$PAL_CONFIG" > "$MISFIRE_DIR/pal.yml"
+    cat > "$MISFIRE_DIR/actions/misfire.yml" <<'EOF'
+misfire:
+  - action: catch_up
+    concurrent: true
+    schedule:
+      - cron: "* * * * * *"
+        seconds: true
+        input: missed
+        misfire:
+          policy: run_all
+          limit: 2
+    cmd: echo "misfire $PAL_INPUT"
+  - action: removed
+    schedule:
+      - "0 1 * * *"
+    cmd: echo removed
+  - action: interrupted
+    background: true
+    cmd: sleep 30
+  - action: protected
+    auth_header: X-Pal-Auth protected
+    cmd: echo protected
+  - action: format
+    output: true
+    cmd: echo not json
+  - action: watched
+    concurrent: true
+    watch:
+      paths:
+        - WATCH_DIR/before
+      events:
+        - create
+    cmd: echo "watched $(basename $PAL_INPUT)"
+  - action: signed
+    output: true
+    concurrent: true
+    auth:
+      type: hmac-sha256
+      header: X-Hub-Signature-256
+      prefix: sha256=
+      secret: old_secret
+    cmd: echo "signed $PAL_INPUT"
+  - action: typed
+    output: true
+    concurrent: true
+    params:
+      - name: level
+        enum:
+          - low
+    cmd: echo "level=$PAL_PARAM_LEVEL"
+  - action: gated
+    concurrent: true
+    cmd: echo gated
+  - action: zoned
+    schedule:
+      - cron: "0 0 * * *"
+        timezone: UTC
+        input: utc
+      - cron: "0 0 * * *"
+        timezone: Asia/Tokyo
+        input: tokyo
+    cmd: echo "zoned $PAL_INPUT"
+  - action: frozen
+    concurrent: true
+    cmd: echo frozen
+workflows:
+  - name: unprotected
+    steps:
+      - name: protected
+        group: misfire
+        action: protected
+EOF
+    mkdir "$MISFIRE_DIR/before" "$MISFIRE_DIR/after"
+    sed -i "s|WATCH_DIR|$MISFIRE_DIR|" "$MISFIRE_DIR/actions/misfire.yml"
+    cat > "$MISFIRE_DIR/actions/invalid.yml" <<'EOF'
+invalid:
+  - action: format_invalid
+    output_format: json
+    cmd: echo "{}"
+EOF
+
+    "$PAL_BIN" -c "$MISFIRE_DIR/pal.yml" -d "$MISFIRE_DIR/actions" > "$MISFIRE_DIR/pal.log" 2>&1 &
+    MISFIRE_PID=$!
+    sleep 3
+    curl -sSk -u "$BASIC_AUTH" "https://$HOST:$MISFIRE_PORT/v1/pal/run/misfire/interrupted" >/dev/null
+    sleep 1
+    kill "$MISFIRE_PID" && wait "$MISFIRE_PID" 2>/dev/null
+    sleep 3
+    "$PAL_BIN" -c "$MISFIRE_DIR/pal.yml" -d "$MISFIRE_DIR/actions" >> "$MISFIRE_DIR/pal.log" 2>&1 &
+    MISFIRE_PID=$!
+    sleep 3
+
+    OUT=$(curl -sSk -u "$BASIC_AUTH" "https://$HOST:$MISFIRE_PORT/v1/pal/runs?group=misfire&action=catch_up")
+    if [ "$(echo "$OUT" | grep -o '"trigger":"misfire","input":"missed"' | wc -l)" -eq 2 ]; then
+        echo "[pass] misfire"
+    else
+        echo "$OUT"
+        cat "$MISFIRE_DIR/pal.log"
+        echo "[fail] misfire" && exit 1
+    fi
+
+    # workflows/auth: a workflow without auth_header can't run a step action that has auth
+    OUT=$(curl -sSk "https://$HOST:$MISFIRE_PORT/v1/pal/workflows/unprotected/run")
+    if ! contains "$OUT" "protected" &&
+        grep -q "error step protected action misfire/protected requires auth" "$MISFIRE_DIR/pal.log"; then
+        echo "[pass] workflows/auth"
+    else
+        echo "$OUT"
+        echo "[fail] workflows/auth" && exit 1
+    fi
+
+    # output_format/validate: output_format json without output: true is rejected on load
+    if grep -q "error action format_invalid output_format json requires output: true" "$MISFIRE_DIR/pal.log"; then
+        echo "[pass] output_format/validate"
+    else
+        cat "$MISFIRE_DIR/pal.log"
+        echo "[fail] output_format/validate" && exit 1
+    fi
+
+    # runs/interrupted: a run left running by the stop is closed as an error on restart
+    OUT=$(curl -sSk -u "$BASIC_AUTH" "https://$HOST:$MISFIRE_PORT/v1/pal/runs?group=misfire&action=interrupted")
+    if contains "$OUT" '"status":"error"' && contains "$OUT" '"reason":"running when pal restarted"'; then
+        echo "[pass] runs/interrupted"
+    else
+        echo "$OUT"
+        echo "[fail] runs/interrupted" && exit 1
+    fi
+
+    # reload/schedules: update the input of a schedule, add an action and remove one
+    cat > "$MISFIRE_DIR/actions/misfire.yml" <<'EOF'
+misfire:
+  - action: catch_up
+    concurrent: true
+    schedule:
+      - cron: "* * * * * *"
+        seconds: true
+        input: reloaded
+    cmd: echo "misfire $PAL_INPUT"
+  - action: added
+    schedule:
+      - "0 0 * * *"
+    cmd: echo added
+  - action: format
+    output: true
+    output_format: json
+    cmd: echo not json
+  - action: watched
+    concurrent: true
+    watch:
+      paths:
+        - WATCH_DIR/after
+      events:
+        - create
+    cmd: echo "watched $(basename $PAL_INPUT)"
+  - action: signed
+    output: true
+    concurrent: true
+    auth:
+      type: hmac-sha256
+      header: X-Hub-Signature-256
+      prefix: sha256=
+      secret: new_secret
+    cmd: echo "signed $PAL_INPUT"
+  - action: typed
+    output: true
+    concurrent: true
+    params:
+      - name: level
+        enum:
+          - high
+      - name: extra
+        default: added
+    cmd: echo "level=$PAL_PARAM_LEVEL extra=$PAL_PARAM_EXTRA"
+  - action: gated
+    concurrent: true
+    approval:
+      required: true
+    cmd: echo gated
+  - action: frozen
+    concurrent: true
+    blocked_windows:
+      - freeze
+    cmd: echo frozen
+  - action: zoned
+    schedule:
+      - cron: "0 0 * * *"
+        timezone: UTC
+        input: utc
+      - cron: "0 0 * * *"
+        timezone: Asia/Tokyo
+        input: tokyo
+    cmd: echo "zoned $PAL_INPUT"
+EOF
+    sed -i "s|WATCH_DIR|$MISFIRE_DIR|" "$MISFIRE_DIR/actions/misfire.yml"
+
+    # A paused schedule stays paused when other options than its cron change
+    curl -sSk -XPOST -d "username=$USER" -d "password=$PASS" --cookie-jar "$MISFIRE_DIR/pal.cookie" "https://$HOST:$MISFIRE_PORT/v1/pal/ui/login" >/dev/null
+    ID=$(curl -sSk -b "$MISFIRE_DIR/pal.cookie" "https://$HOST:$MISFIRE_PORT/v1/pal/schedules" | sed 's/.*"action":"catch_up","id":"\([^"]*\)".*/\1/')
+    curl -sSk -XPOST -b "$MISFIRE_DIR/pal.cookie" "https://$HOST:$MISFIRE_PORT/v1/pal/schedules/pause?id=$ID" >/dev/null
+    OUT=$(curl -sSk -XPOST -b "$MISFIRE_DIR/pal.cookie" "https://$HOST:$MISFIRE_PORT/v1/pal/actions/reload")
+    PAUSED=$(curl -sSk -b "$MISFIRE_DIR/pal.cookie" "https://$HOST:$MISFIRE_PORT/v1/pal/schedules")
+    if contains "$OUT" '{"added":["misfire/added 0 0 * * *"],"removed":["misfire/removed 0 1 * * *"],"updated":["misfire/catch_up * * * * * *"]}' &&
+        contains "$PAUSED" '"cron":"* * * * * *","input":"reloaded","paused":true'; then
+        echo "[pass] reload/schedules"
+    else
+        echo "$OUT $PAUSED"
+        echo "[fail] reload/schedules" && exit 1
+    fi
+
+    ID=$(echo "$PAUSED" | sed 's/.*"action":"catch_up","id":"\([^"]*\)".*/\1/')
+    curl -sSk -XPOST -b "$MISFIRE_DIR/pal.cookie" "https://$HOST:$MISFIRE_PORT/v1/pal/schedules/resume?id=$ID" >/dev/null
+
+    sleep 2
+    OUT=$(curl -sSk -u "$BASIC_AUTH" "https://$HOST:$MISFIRE_PORT/v1/pal/runs?group=misfire&action=catch_up")
+    if contains "$OUT" '"trigger":"schedule","input":"reloaded"'; then
+        echo "[pass] reload/schedules_run"
+    else
+        echo "$OUT"
+        echo "[fail] reload/schedules_run" && exit 1
+    fi
+
+    # schedules/pause_timezone: the same cron in another timezone is its own schedule with its own pause
+    ID=$(curl -sSk -b "$MISFIRE_DIR/pal.cookie" "https://$HOST:$MISFIRE_PORT/v1/pal/schedules" | grep -o '{[^{}]*"input":"utc"[^{}]*}' | sed 's/.*"id":"\([^"]*\)".*/\1/')
+    curl -sSk -XPOST -b "$MISFIRE_DIR/pal.cookie" "https://$HOST:$MISFIRE_PORT/v1/pal/schedules/pause?id=$ID" >/dev/null
+    OUT=$(curl -sSk -b "$MISFIRE_DIR/pal.cookie" "https://$HOST:$MISFIRE_PORT/v1/pal/schedules")
+    if contains "$OUT" '"input":"utc","paused":true' && contains "$OUT" '"input":"tokyo","paused":false'; then
+        echo "[pass] schedules/pause_timezone"
+    else
+        echo "$OUT"
+        echo "[fail] schedules/pause_timezone" && exit 1
+    fi
+
+    # reload/output_format: switching an existing action to output_format json takes effect on reload
+    OUT=$(curl -sSk "https://$HOST:$MISFIRE_PORT/v1/pal/run/misfire/format")
+    if contains "$OUT" "output_format json output is not valid json"; then
+        echo "[pass] reload/output_format"
+    else
+        echo "$OUT"
+        echo "[fail] reload/output_format" && exit 1
+    fi
+
+    # reload/auth: a rotated auth secret of an existing action takes effect on reload
+    OLD=$(printf 'rotated' | openssl dgst -sha256 -hmac old_secret | cut -d' ' -f2)
+    NEW=$(printf 'rotated' | openssl dgst -sha256 -hmac new_secret | cut -d' ' -f2)
+    BAD=$(curl -sSk -XPOST -H "X-Hub-Signature-256: sha256=$OLD" -d 'rotated' "https://$HOST:$MISFIRE_PORT/v1/pal/run/misfire/signed")
+    OUT=$(curl -sSk -XPOST -H "X-Hub-Signature-256: sha256=$NEW" -d 'rotated' "https://$HOST:$MISFIRE_PORT/v1/pal/run/misfire/signed")
+    if contains "$OUT" "signed rotated" && ! contains "$BAD" "signed rotated"; then
+        echo "[pass] reload/auth"
+    else
+        echo "$OUT $BAD"
+        echo "[fail] reload/auth" && exit 1
+    fi
+
+    # reload/params: an edited params schema of an existing action validates on reload
+    OUT=$(curl -sSk "https://$HOST:$MISFIRE_PORT/v1/pal/run/misfire/typed?level=high")
+    BAD=$(curl -sSk "https://$HOST:$MISFIRE_PORT/v1/pal/run/misfire/typed?level=low")
+    if contains "$OUT" "level=high extra=added" && ! contains "$BAD" "level=low"; then
+        echo "[pass] reload/params"
+    else
+        echo "$OUT $BAD"
+        echo "[fail] reload/params" && exit 1
+    fi
+
+    # reload/approval: approval and windows added to existing actions take effect on reload
+    GATED=$(curl -sSk -w ' %{http_code}' "https://$HOST:$MISFIRE_PORT/v1/pal/run/misfire/gated")
+    FROZEN=$(curl -sSk -w ' %{http_code}' "https://$HOST:$MISFIRE_PORT/v1/pal/run/misfire/frozen")
+    if contains "$GATED" "pending approval 202" && contains "$FROZEN" "blocked by calendar freeze 423"; then
+        echo "[pass] reload/approval"
+    else
+        echo "$GATED $FROZEN"
+        echo "[fail] reload/approval" && exit 1
+    fi
+
+    # reload/watch: a changed watch path of an existing action is watched after reload, the old one isn't
+    touch "$MISFIRE_DIR/before/old.txt" "$MISFIRE_DIR/after/new.txt"
+    sleep 1
+    OUT=$(curl -sSk -u "$BASIC_AUTH" "https://$HOST:$MISFIRE_PORT/v1/pal/runs?group=misfire&action=watched")
+    if contains "$OUT" '"trigger":"watch"' && contains "$OUT" "new.txt" && ! contains "$OUT" "old.txt"; then
+        echo "[pass] reload/watch"
+    else
+        echo "$OUT"
+        echo "[fail] reload/watch" && exit 1
+    fi
+
+    # leader/system: the only node sharing the lease file is the leader
+    OUT=$(curl -sSk -b "$MISFIRE_DIR/pal.cookie" "https://$HOST:$MISFIRE_PORT/v1/pal/ui/system")
+    if contains "$OUT" '<pre class="text-wrap">pal-a (this node)</pre>'; then
+        echo "[pass] leader/system"
+    else
+        echo "$OUT"
+        echo "[fail] leader/system" && exit 1
+    fi
+
+    # leader/same_node: a third pal with the same node name takes over the lease like a restart, the first one stands by
+    LEADER_PORT=$((PORT + 2))
+    mkdir "$MISFIRE_DIR/leader"
+    sed -e "s|^  listen: .*|  listen: $HOST:$LEADER_PORT|" -e "s|pal.db|leader.db|" \
+        "$MISFIRE_DIR/pal.yml" > "$MISFIRE_DIR/leader.yml"
+    cat > "$MISFIRE_DIR/leader/leader.yml" <<'EOF'
+leader:
+  - action: standby
+    schedule:
+      - "0 0 * * *"
+    cmd: echo standby
+EOF
+    "$PAL_BIN" -c "$MISFIRE_DIR/leader.yml" -d "$MISFIRE_DIR/leader" > "$MISFIRE_DIR/leader.log" 2>&1 &
+    LEADER_PID=$!
+    sleep 3
+    curl -sSk -XPOST -d "username=$USER" -d "password=$PASS" --cookie-jar "$MISFIRE_DIR/leader.cookie" "https://$HOST:$LEADER_PORT/v1/pal/ui/login" >/dev/null
+
+    STANDBY=$(curl -sSk -o /dev/null -w "%{http_code}" -b "$MISFIRE_DIR/pal.cookie" "https://$HOST:$MISFIRE_PORT/v1/pal/schedules?group=misfire&action=added&run=now")
+    OUT=$(curl -sSk -b "$MISFIRE_DIR/leader.cookie" "https://$HOST:$LEADER_PORT/v1/pal/schedules?group=leader&action=standby&run=now")
+    if [ "$STANDBY" = "409" ] && contains "$OUT" '"running"' &&
+        grep -q "renewed by another process with node name pal-a" "$MISFIRE_DIR/pal.log"; then
+        echo "[pass] leader/same_node"
+    else
+        echo "$STANDBY $OUT"
+        cat "$MISFIRE_DIR/pal.log" "$MISFIRE_DIR/leader.log"
+        echo "[fail] leader/same_node" && exit 1
+    fi
+
+    # leader/takeover: the standby takes over once the leader stops renewing the lease for the ttl
+    kill "$LEADER_PID" && wait "$LEADER_PID" 2>/dev/null
+    LEADER_PID=""
+    sleep 5
+    OUT=$(curl -sSk -b "$MISFIRE_DIR/pal.cookie" "https://$HOST:$MISFIRE_PORT/v1/pal/schedules?group=misfire&action=added&run=now")
+    if contains "$OUT" '"running"'; then
+        echo "[pass] leader/takeover"
+    else
+        echo "$OUT"
+        cat "$MISFIRE_DIR/pal.log"
+        echo "[fail] leader/takeover" && exit 1
+    fi
+else
+    echo "[skip] misfire, reload/schedules and leader, needs -bin and -config to restart pal"
+fi
@@ -5,14 +5,145 @@ const tooltipList = [...tooltipTriggerList].map(
   (tooltipTriggerEl) => new bootstrap.Tooltip(tooltipTriggerEl)
 );
 
+function setOutputStatus(ok) {
+  const outputStatus = document.getElementById("outputStatus");
+  if (ok) {
+    outputStatus.textContent = "check_circle";
+    outputStatus.style.color = "green";
+  } else {
+    outputStatus.textContent = "error";
+    outputStatus.style.color = "red";
+  }
+}
+
+// Parse Server-Sent Events from the run response body and append output as it arrives
+async function streamData(runURL, data, outputPre) {
+  const response = await fetch(runURL + "?stream=true", {
+    method: "POST",
+    headers: { "Content-Type": "text/plain", Accept: "text/event-stream" },
+    body: data,
+  });
+  if (!response.ok || !response.body) {
+    const text = await response.text();
+    throw new Error(
+      `Network response was not ok (Status ${response.status}: ${response.statusText}) ${text}`
+    );
+  }
+
+  outputPre.textContent = "";
+  const reader = response.body.pipeThrough(new TextDecoderStream()).getReader();
+  let buffer = "";
+  let status = "error";
+
+  while (true) {
+    const { value, done } = await reader.read();
+    if (done) break;
+    buffer += value;
+    const events = buffer.split("\n\n");
+    buffer = events.pop();
+    events.forEach((raw) => {
+      let event = "message";
+      const lines = [];
+      raw.split("\n").forEach((line) => {
+        if (line.startsWith("event: ")) {
+          event = line.slice(7);
+        } else if (line.startsWith("data: ")) {
+          lines.push(line.slice(6));
+        }
+      });
+      if (event === "done") {
+        // done carries the run status and exit code as JSON
+        status = JSON.parse(lines.join("\n")).status;
+      } else {
+        outputPre.textContent += lines.join("\n") + "\n";
+      }
+    });
+  }
+
+  return status;
+}
+
+// Cancel every active run of the action shown on the action page
+async function cancelRuns() {
+  const outputPre = document.getElementById("outputPre");
+  const runButton = document.getElementById("runNowBtn");
+  const params = new URLSearchParams({
+    group: runButton.dataset.group,
+    action: runButton.dataset.action,
+    status: "running",
+  });
+
+  try {
+    const response = await fetch(`/v1/pal/runs?${params}`);
+    const runs = await response.json();
+    const results = await Promise.all(
+      runs.map((run) =>
+        fetch(`/v1/pal/runs/${run.id}`, { method: "DELETE" }).then((res) =>
+          res.json()
+        )
+      )
+    );
+    const cancelled = results.filter((res) => res.msg).map((res) => res.msg);
+    if (runButton.dataset.stream !== "true" || cancelled.length === 0) {
+      outputPre.textContent =
+        cancelled.length > 0 ? cancelled.join("\n") : "no running runs to cancel";
+    }
+  } catch (error) {
+    console.error(error);
+    outputPre.textContent = error;
+  }
+}
+
+// paramsData returns the params form as a JSON object, or the input textarea value
+function paramsData() {
+  const form = document.getElementById("paramsForm");
+  if (!form) {
+    return document.getElementById("inputInput").value;
+  }
+
+  const params = {};
+  form.querySelectorAll("[data-param]").forEach((el) => {
+    const name = el.dataset.param;
+    if (el.dataset.type === "boolean") {
+      params[name] = el.checked;
+    } else if (el.value !== "") {
+      const isNumber = el.dataset.type === "number" || el.dataset.type === "integer";
+      params[name] = isNumber ? Number(el.value) : el.value;
+    }
+  });
+
+  return JSON.stringify(params);
+}
+
 function sendData() {
-  const data = document.getElementById("inputInput").value;
+  const form = document.getElementById("paramsForm");
+  if (form && !form.reportValidity()) {
+    return;
+  }
+  const data = paramsData();
   const outputPre = document.getElementById("outputPre");
   const runIcon = document.getElementById("runIcon");
+  const runButton = document.getElementById("runNowBtn");
   const runURL = window.location.pathname + "/run";
 
   runIcon.classList.add("spin-animation");
 
+  if (runButton.dataset.stream === "true") {
+    streamData(runURL, data, outputPre)
+      .then((status) => {
+        setOutputStatus(status === "success");
+      })
+      .catch((error) => {
+        console.error(error);
+        outputPre.textContent = error;
+        setOutputStatus(false);
+      })
+      .finally(() => {
+        runIcon.classList.remove("spin-animation");
+      });
+    return;
+  }
+
   fetch(runURL, {
     method: "POST",
     headers: { "Content-Type": "text/plain" },
@@ -131,6 +262,11 @@ document.addEventListener("DOMContentLoaded", () => {
     runButton.addEventListener("click", sendData);
   }
 
+  const cancelRunButton = document.getElementById("cancelRunBtn");
+  if (cancelRunButton) {
+    cancelRunButton.addEventListener("click", cancelRuns);
+  }
+
   const copyButton = document.getElementById("copyBtn");
   if (copyButton) {
     copyButton.addEventListener("click", copyToClipboard);
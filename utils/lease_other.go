@@ -0,0 +1,57 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// pal - github.com/marshyski/pal
+// Copyright (C) 2024-2025  github.com/marshyski
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !unix
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+// errNotLeader is returned by IsLeader of a standby node
+var errNotLeader = errors.New("error node is not the leader")
+
+// FileLease needs flock, which is not supported on this platform, so the node never becomes the leader
+type FileLease struct {
+	path string
+	node string
+}
+
+func NewFileLease(path, node string, _ time.Duration) *FileLease {
+	return &FileLease{path: filepath.Clean(path), node: node}
+}
+
+func (l *FileLease) Start() {
+	log.Println("error lease " + l.path + " leader.type file is not supported on this platform, schedules won't run")
+}
+
+func (l *FileLease) IsLeader(_ context.Context) error {
+	return errNotLeader
+}
+
+func (l *FileLease) Leader() string {
+	return ""
+}
+
+func (l *FileLease) Node() string {
+	return l.node
+}
@@ -0,0 +1,165 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// pal - github.com/marshyski/pal
+// Copyright (C) 2024-2025  github.com/marshyski
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build unix
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errNotLeader is returned by IsLeader of a standby node
+var errNotLeader = errors.New("error node is not the leader")
+
+// FileLease elects a leader with a lease file on a path shared by every node. The leader renews the lease
+// every third of its TTL under flock, another node takes it over once it expires. Clocks of the nodes need to be in sync
+type FileLease struct {
+	path  string
+	node  string
+	ttl   time.Duration
+	token string
+	mu    sync.RWMutex
+	// held is set once this process wrote the lease, conflict once another process renewed it under the same node name
+	held     bool
+	conflict bool
+	leading  bool
+	leader   string
+	expires  time.Time
+}
+
+func NewFileLease(path, node string, ttl time.Duration) *FileLease {
+	return &FileLease{path: filepath.Clean(path), node: node, ttl: ttl, token: uuid.NewString()}
+}
+
+// Start takes or checks the lease right away, then renews it in the background
+func (l *FileLease) Start() {
+	if err := l.renew(); err != nil {
+		log.Println("error lease " + l.path + " " + err.Error())
+	}
+
+	go func() {
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := l.renew(); err != nil {
+				log.Println("error lease " + l.path + " " + err.Error())
+			}
+		}
+	}()
+}
+
+// IsLeader returns nil while this process holds an unexpired lease, it satisfies gocron.Elector
+func (l *FileLease) IsLeader(_ context.Context) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if l.leading && time.Now().Before(l.expires) {
+		return nil
+	}
+
+	return errNotLeader
+}
+
+// Leader returns the node holding the lease, empty when it expired
+func (l *FileLease) Leader() string {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if time.Now().After(l.expires) {
+		return ""
+	}
+
+	return l.leader
+}
+
+func (l *FileLease) Node() string {
+	return l.node
+}
+
+// renew reads the lease under an exclusive flock and takes it when it's free, expired or already this process's.
+// A lease of this node name from another process is taken over once after a restart, but once this process held
+// it, another process renewing it means two nodes share the node name and this one stands by
+func (l *FileLease) renew() error {
+	f, err := os.OpenFile(l.path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer func() { _ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN) }()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+
+	// A lease is the node name, its expiry and the token of the process holding it on separate lines
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	lines = append(lines, "", "", "")
+	holder, token := strings.TrimSpace(lines[0]), strings.TrimSpace(lines[2])
+	expires, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(lines[1]))
+	if err != nil {
+		holder = ""
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	ours := token == l.token
+	conflict := !ours && holder == l.node && l.held && now.Before(expires)
+	if conflict && !l.conflict {
+		log.Printf("error lease %s is renewed by another process with node name %s, set a unique leader.node on every node", l.path, l.node)
+	}
+	l.conflict = conflict
+
+	if !conflict && (holder == "" || ours || holder == l.node || now.After(expires)) {
+		holder, expires, ours = l.node, now.Add(l.ttl), true
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.WriteAt([]byte(holder+"\n"+expires.Format(time.RFC3339Nano)+"\n"+l.token+"\n"), 0); err != nil {
+			return err
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+		l.held = true
+	}
+
+	if holder != l.leader || ours != l.leading {
+		log.Printf("scheduler leader is %s, this node is %s", holder, l.node)
+	}
+	l.leader, l.expires, l.leading = holder, expires, ours
+
+	return nil
+}
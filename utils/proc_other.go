@@ -0,0 +1,31 @@
+// SPDX-License-Identifier: AGPL-3.0-only
+// pal - github.com/marshyski/pal
+// Copyright (C) 2024-2025  github.com/marshyski
+
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !unix
+
+package utils
+
+import (
+	"os/exec"
+)
+
+// setProcGroup kills only the command process, process groups are not supported on this platform
+func setProcGroup(command *exec.Cmd) {
+	command.Cancel = func() error {
+		return command.Process.Kill()
+	}
+}
@@ -18,20 +18,34 @@
 package utils
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
+	"log"
+	"maps"
+	"math"
+	mathrand "math/rand/v2"
 	"net/http"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"crypto/rand"
 	"crypto/tls"
 
+	"github.com/goccy/go-json"
 	"github.com/marshyski/pal/data"
+	"github.com/robfig/cron/v3"
+	"github.com/santhosh-tekuri/jsonschema/v6"
 	"golang.org/x/net/http2"
 )
 
@@ -41,6 +55,7 @@ const (
 	minute            = 60
 	httpClientTimeout = 15
 	httpRedirectMax   = 10
+	waitDelay         = 5 * time.Second
 )
 
 // TimeNow
@@ -71,54 +86,744 @@ func GenSecret() string {
 	return secret
 }
 
-// CmdRun runs a shell command or script and returns output with error
-func CmdRun(action data.ActionData, prefix, workingDir string) (string, string, error) {
+// CmdRun runs a shell command or script, or the args binary directly without a shell, and returns stdout, stderr
+// and exit code with error. env is added to the pal environment, stdout and stderr writers if not nil receive output
+// as it's written. Every attempt gets its own timeout and failed attempts are retried with on_error retry settings
+func CmdRun(parent context.Context, action data.ActionData, prefix, workingDir string, env []string, stdout, stderr io.Writer) (data.CmdResult, error) {
 	startTime := time.Now()
 
 	if action.Timeout == 0 {
 		action.Timeout = 600
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(action.Timeout)*time.Second)
-	defer cancel()
-
-	var output []byte
-	var err error
-
 	var cmdPrefix []string
-	if action.CmdPrefix != "" {
+	switch {
+	case len(action.Args) > 0:
+		cmdPrefix = action.Args
+	case action.CmdPrefix != "":
 		cmdPrefix = append(strings.Split(action.CmdPrefix, " "), action.Cmd)
-	} else {
+	default:
 		cmdPrefix = append(strings.Split(prefix, " "), action.Cmd)
 	}
 
+	res := data.CmdResult{}
+	var err error
+
 	// Retry loop
 	for attempt := 0; attempt <= action.OnError.Retries; attempt++ {
-		command := exec.CommandContext(ctx, cmdPrefix[0], cmdPrefix[1:]...) // #nosec G204
-		command.Dir = workingDir
-		output, err = command.Output()
+		attemptStart := time.Now()
+		var stdoutStr, stderrStr string
+		stdoutStr, stderrStr, err = cmdAttempt(parent, time.Duration(action.Timeout)*time.Second, cmdPrefix, action.Container.Stop, workingDir, env, stdout, stderr)
+		res.Stdout, res.Stderr = stdoutStr, stderrStr
+		res.ExitCode = ExitCode(err)
+
+		// Exit codes set as success_exit_codes are not a failure
+		if err != nil && res.ExitCode > 0 && slices.Contains(action.SuccessExitCodes, res.ExitCode) {
+			err = nil
+		}
+
+		status := "success"
+		if err != nil {
+			status = "error"
+		}
+		res.Attempts = append(res.Attempts, data.Attempt{
+			Attempt:  attempt + 1,
+			Duration: FmtDuration(int(time.Since(attemptStart).Seconds())),
+			ExitCode: res.ExitCode,
+			Status:   status,
+			Stdout:   stdoutStr,
+			Stderr:   stderrStr,
+		})
 
 		if err == nil {
 			break // Command succeeded, exit the loop
 		}
 
-		// If it's not a context timeout, retry
-		if ctx.Err() != context.DeadlineExceeded {
-			if attempt < action.OnError.Retries {
-				// TODO: DEBUG LOG
-				// fmt.Printf("Command failed, retrying in %d seconds (attempt %d/%d)...\n", action.OnError.RetryInterval, attempt+1, action.OnError.Retries)
-				time.Sleep(time.Duration(action.OnError.RetryInterval) * time.Second)
+		// Retry unless cancelled, out of retries or the failure doesn't match retry_on
+		if parent.Err() == nil && attempt < action.OnError.Retries && RetryOn(action.OnError.RetryOn, res) {
+			timer := time.NewTimer(RetryDelay(action.OnError, attempt))
+			select {
+			case <-timer.C:
 				continue
+			case <-parent.Done():
+				timer.Stop()
+			}
+		}
+
+		// If it's cancelled or the maximum retries are reached, return the error
+		res.Duration = FmtDuration(int(time.Since(startTime).Seconds()))
+		return res, fmt.Errorf("error after %d retries in %d seconds : %s %w", attempt, int(time.Since(startTime).Seconds()), stdoutStr, err)
+	}
+
+	res.Duration = FmtDuration(int(time.Since(startTime).Seconds()))
+	return res, nil
+}
+
+// cmdAttempt runs the command once with its own timeout and returns trimmed stdout and stderr.
+// Killing a container runtime client leaves its container running, stop kills the container too
+func cmdAttempt(parent context.Context, timeout time.Duration, cmdPrefix, stop []string, workingDir string, env []string, stdout, stderr io.Writer) (string, string, error) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+
+	command := exec.CommandContext(ctx, cmdPrefix[0], cmdPrefix[1:]...) // #nosec G204
+	command.Dir = workingDir
+	command.Env = append(os.Environ(), env...)
+	setProcGroup(command)
+	if len(stop) > 0 {
+		kill := command.Cancel
+		command.Cancel = func() error {
+			if err := exec.Command(stop[0], stop[1:]...).Run(); err != nil { // #nosec G204
+				log.Println("error stopping container " + strings.Join(stop, " ") + " " + err.Error())
+			}
+			return kill()
+		}
+	}
+	command.WaitDelay = waitDelay
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	command.Stdout = &stdoutBuf
+	command.Stderr = &stderrBuf
+	if stdout != nil {
+		command.Stdout = io.MultiWriter(&stdoutBuf, stdout)
+	}
+	if stderr != nil {
+		command.Stderr = io.MultiWriter(&stderrBuf, stderr)
+	}
+
+	err := command.Run()
+
+	return strings.TrimSpace(stdoutBuf.String()), strings.TrimSpace(stderrBuf.String()), err
+}
+
+// RetryOn checks if a failed attempt should be retried, when retry_on is empty every failure is retried.
+// Output patterns are regular expressions matched against stdout and stderr, validated on load
+func RetryOn(retryOn data.RetryOn, res data.CmdResult) bool {
+	if len(retryOn.ExitCodes) == 0 && len(retryOn.Output) == 0 {
+		return true
+	}
+
+	if slices.Contains(retryOn.ExitCodes, res.ExitCode) {
+		return true
+	}
+
+	for _, pattern := range retryOn.Output {
+		re, err := regexp.Compile(pattern)
+		if err == nil && (re.MatchString(res.Stdout) || re.MatchString(res.Stderr)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// When checks the stdout and duration of a run against the when conditions of a triggered run,
+// the error is the first condition that doesn't hold. The output regex is validated on load
+func When(when data.When, stdout string, duration time.Duration) error {
+	if when.Output != "" {
+		re, err := regexp.Compile(when.Output)
+		if err != nil || !re.MatchString(stdout) {
+			return fmt.Errorf("output does not match %s", when.Output)
+		}
+	}
+
+	if when.JSONField != "" {
+		value, err := JSONField(stdout, when.JSONField)
+		if err != nil {
+			return err
+		}
+		if value != when.JSONValue {
+			return fmt.Errorf("json field %s is %s not %s", when.JSONField, value, when.JSONValue)
+		}
+	}
+
+	if when.MinDuration > 0 && duration < time.Duration(when.MinDuration)*time.Second {
+		return fmt.Errorf("duration %s is under min_duration %ds", duration.Round(time.Second), when.MinDuration)
+	}
+
+	if when.MaxDuration > 0 && duration > time.Duration(when.MaxDuration)*time.Second {
+		return fmt.Errorf("duration %s is over max_duration %ds", duration.Round(time.Second), when.MaxDuration)
+	}
+
+	return nil
+}
+
+// JSONField returns the value at a dot separated path of a JSON document, numbers index arrays.
+// String values are returned unquoted and any other value as JSON
+func JSONField(doc, path string) (string, error) {
+	var value any
+	err := json.Unmarshal([]byte(doc), &value)
+	if err != nil {
+		return "", fmt.Errorf("output is not json %w", err)
+	}
+
+	for _, key := range strings.Split(path, ".") {
+		switch node := value.(type) {
+		case map[string]any:
+			v, ok := node[key]
+			if !ok {
+				return "", fmt.Errorf("json field %s not found", path)
 			}
+			value = v
+		case []any:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return "", fmt.Errorf("json field %s not found", path)
+			}
+			value = node[i]
+		default:
+			return "", fmt.Errorf("json field %s not found", path)
 		}
+	}
+
+	if v, ok := value.(string); ok {
+		return v, nil
+	}
+
+	out, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
+
+// ParseCron parses a cron expression like the scheduler, with a leading seconds field if seconds is set, in loc
+// unless the expression sets its own CRON_TZ
+func ParseCron(expr string, seconds bool, loc *time.Location) (cron.Schedule, error) {
+	if !strings.HasPrefix(expr, "TZ=") && !strings.HasPrefix(expr, "CRON_TZ=") {
+		expr = "CRON_TZ=" + loc.String() + " " + expr
+	}
+
+	if seconds {
+		p := cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+		return p.Parse(expr)
+	}
+
+	return cron.ParseStandard(expr)
+}
 
-		errStr := fmt.Sprintf("error after %d retries in %d seconds : %s %s", action.OnError.Retries, int(time.Since(startTime).Seconds()), strings.TrimSpace(string(output)), err.Error())
+// NextRuns returns the next count times a cron expression fires after from
+func NextRuns(expr string, seconds bool, loc *time.Location, from time.Time, count int) ([]time.Time, error) {
+	schedule, err := ParseCron(expr, seconds, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := []time.Time{}
+	for next := schedule.Next(from); !next.IsZero() && len(runs) < count; next = schedule.Next(next) {
+		runs = append(runs, next)
+	}
+
+	return runs, nil
+}
+
+// Misfires returns up to limit times a cron expression should have fired after last and up to now
+func Misfires(expr string, seconds bool, loc *time.Location, last, now time.Time, limit int) ([]time.Time, error) {
+	schedule, err := ParseCron(expr, seconds, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	var misfires []time.Time
+	for next := schedule.Next(last); !next.IsZero() && !next.After(now) && len(misfires) < limit; next = schedule.Next(next) {
+		misfires = append(misfires, next)
+	}
+
+	return misfires, nil
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// LoadCalendar checks the dates and windows of a calendar and reads the events of its iCal file as holidays,
+// dates without a time are in the calendar timezone or loc
+func LoadCalendar(cal *data.Calendar, loc *time.Location) error {
+	loc = calendarLocation(*cal, loc)
+
+	for _, d := range cal.Dates {
+		if _, _, err := dateRange(d, loc); err != nil {
+			return err
+		}
+	}
+
+	for _, w := range cal.Windows {
+		if _, _, err := windowClock(w); err != nil {
+			return err
+		}
+	}
+
+	if cal.ICal == "" {
+		return nil
+	}
+
+	holidays, err := ReadICal(cal.ICal, loc)
+	if err != nil {
+		return fmt.Errorf("error reading ical %s: %w", cal.ICal, err)
+	}
+	cal.Holidays = holidays
+
+	return nil
+}
+
+// InCalendar reports whether t is in a date range, window or holiday of the calendar
+func InCalendar(cal data.Calendar, loc *time.Location, t time.Time) bool {
+	loc = calendarLocation(cal, loc)
+	t = t.In(loc)
+
+	for _, d := range cal.Dates {
+		start, end, err := dateRange(d, loc)
+		if err == nil && !t.Before(start) && t.Before(end) {
+			return true
+		}
+	}
+
+	for _, w := range cal.Windows {
+		if inWindow(w, t) {
+			return true
+		}
+	}
+
+	for _, h := range cal.Holidays {
+		if inHoliday(h, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func calendarLocation(cal data.Calendar, loc *time.Location) *time.Location {
+	if cal.Timezone != "" {
+		if l, err := time.LoadLocation(cal.Timezone); err == nil {
+			return l
+		}
+	}
+
+	return loc
+}
+
+// dateRange returns the start and exclusive end of a date range, a date without a time covers the whole day
+func dateRange(d data.DateRange, loc *time.Location) (time.Time, time.Time, error) {
+	start, _, err := parseDate(d.Start, loc)
+	if err != nil {
+		return start, start, err
+	}
+
+	end, allDay, err := parseDate(cmp.Or(d.End, d.Start), loc)
+	if err != nil {
+		return start, end, err
+	}
+	if allDay {
+		end = end.AddDate(0, 0, 1)
+	}
+	if !end.After(start) {
+		return start, end, fmt.Errorf("error date range %s to %s ends before it starts", d.Start, d.End)
+	}
+
+	return start, end, nil
+}
+
+func parseDate(value string, loc *time.Location) (time.Time, bool, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, false, nil
+	}
+
+	t, err := time.ParseInLocation(time.DateOnly, value, loc)
+	if err != nil {
+		return t, false, fmt.Errorf("error date %s is not YYYY-MM-DD or RFC3339", value)
+	}
+
+	return t, true, nil
+}
+
+// windowClock returns the start and end minutes of the day of a window, from 00:00 to 24:00 by default
+func windowClock(w data.Window) (int, int, error) {
+	start, err := clockMinutes(cmp.Or(w.Start, "00:00"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	end, err := clockMinutes(cmp.Or(w.End, "24:00"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return start, end, nil
+}
+
+func clockMinutes(value string) (int, error) {
+	if value == "24:00" {
+		return 24 * 60, nil
+	}
+
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("error window time %s is not HH:MM", value)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func inWindow(w data.Window, t time.Time) bool {
+	start, end, err := windowClock(w)
+	if err != nil {
+		return false
+	}
+
+	onDay := func(day time.Weekday) bool {
+		return len(w.Days) == 0 || slices.ContainsFunc(w.Days, func(d string) bool { return weekdays[d] == day })
+	}
+
+	minutes := t.Hour()*60 + t.Minute()
+	if start < end {
+		return onDay(t.Weekday()) && minutes >= start && minutes < end
+	}
+
+	// The window ends on the next day
+	return (onDay(t.Weekday()) && minutes >= start) || (onDay((t.Weekday()+6)%7) && minutes < end)
+}
+
+func inHoliday(h data.Holiday, t time.Time) bool {
+	if !h.Yearly {
+		return !t.Before(h.Start) && t.Before(h.End)
+	}
+
+	// A yearly holiday of the previous year can span into this one
+	for _, years := range []int{t.Year() - h.Start.Year() - 1, t.Year() - h.Start.Year()} {
+		if years >= 0 && !t.Before(h.Start.AddDate(years, 0, 0)) && t.Before(h.End.AddDate(years, 0, 0)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ReadICal reads the events of an iCal file as holidays, events without an end last a day and
+// RRULE FREQ=YEARLY events repeat every year, other recurrence rules are ignored
+func ReadICal(file string, loc *time.Location) ([]data.Holiday, error) {
+	b, err := os.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+
+	// Unfold lines continued with a leading space or tab
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(string(b), "\r\n", "\n"), "\n") {
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	var holidays []data.Holiday
+	var holiday data.Holiday
+	inEvent := false
+	for _, line := range lines {
+		name, value, ok := strings.Cut(strings.TrimSpace(line), ":")
+		if !ok {
+			continue
+		}
+		prop, params, _ := strings.Cut(name, ";")
+
+		switch strings.ToUpper(prop) {
+		case "BEGIN":
+			if strings.EqualFold(value, "VEVENT") {
+				holiday = data.Holiday{}
+				inEvent = true
+			}
+		case "END":
+			if strings.EqualFold(value, "VEVENT") && inEvent {
+				inEvent = false
+				if holiday.Start.IsZero() {
+					continue
+				}
+				if !holiday.End.After(holiday.Start) {
+					holiday.End = holiday.Start.AddDate(0, 0, 1)
+				}
+				holidays = append(holidays, holiday)
+			}
+		case "SUMMARY":
+			holiday.Summary = value
+		case "RRULE":
+			holiday.Yearly = strings.Contains(strings.ToUpper(value), "FREQ=YEARLY")
+		case "DTSTART", "DTEND":
+			if !inEvent {
+				continue
+			}
+			t, err := icalTime(value, params, loc)
+			if err != nil {
+				return nil, err
+			}
+			if strings.EqualFold(prop, "DTSTART") {
+				holiday.Start = t
+			} else {
+				holiday.End = t
+			}
+		}
+	}
+
+	return holidays, nil
+}
+
+// icalTime parses an iCal DATE or DATE-TIME value, in UTC, its TZID param or loc
+func icalTime(value, params string, loc *time.Location) (time.Time, error) {
+	for _, param := range strings.Split(params, ";") {
+		if k, v, ok := strings.Cut(param, "="); ok && strings.EqualFold(k, "TZID") {
+			l, err := time.LoadLocation(strings.Trim(v, `"`))
+			if err != nil {
+				return time.Time{}, err
+			}
+			loc = l
+		}
+	}
+
+	switch {
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse("20060102T150405Z", value)
+	case strings.Contains(value, "T"):
+		return time.ParseInLocation("20060102T150405", value, loc)
+	default:
+		return time.ParseInLocation("20060102", value, loc)
+	}
+}
+
+// ParamValue converts the string value of a param, e.g. a default or query param, to its JSON type
+func ParamValue(paramType, value string) (any, error) {
+	switch paramType {
+	case "number", "integer":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return nil, fmt.Errorf("%q is not a %s", value, paramType)
+		}
+		return json.Number(value), nil
+	case "boolean":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", value)
+		}
+		return b, nil
+	}
+
+	return value, nil
+}
+
+// ParamsSchema returns the JSON Schema of an object with the params as properties
+func ParamsSchema(params []data.Param) (map[string]any, error) {
+	properties := make(map[string]any)
+	required := []string{}
+
+	for _, p := range params {
+		property := map[string]any{"type": cmp.Or(p.Type, "string")}
+		if p.Desc != "" {
+			property["description"] = p.Desc
+		}
+		if p.Regex != "" {
+			property["pattern"] = p.Regex
+		}
+		if p.Default != "" {
+			v, err := ParamValue(p.Type, p.Default)
+			if err != nil {
+				return nil, fmt.Errorf("param %s default %w", p.Name, err)
+			}
+			property["default"] = v
+		}
+		if len(p.Enum) > 0 {
+			enum := make([]any, 0, len(p.Enum))
+			for _, e := range p.Enum {
+				v, err := ParamValue(p.Type, e)
+				if err != nil {
+					return nil, fmt.Errorf("param %s enum %w", p.Name, err)
+				}
+				enum = append(enum, v)
+			}
+			property["enum"] = enum
+		}
+		if p.Required {
+			required = append(required, p.Name)
+		}
+		properties[p.Name] = property
+	}
+
+	return map[string]any{
+		"$schema":    "https://json-schema.org/draft/2020-12/schema",
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}, nil
+}
+
+// CompileParams compiles the JSON Schema of params for validating input
+func CompileParams(params []data.Param) (*jsonschema.Schema, error) {
+	schema, err := ParamsSchema(params)
+	if err != nil {
+		return nil, err
+	}
+
+	// Round trip through JSON, the compiler only takes values as unmarshaled by jsonschema
+	b, err := json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	c := jsonschema.NewCompiler()
+	if err := c.AddResource("params.json", doc); err != nil {
+		return nil, err
+	}
+
+	return c.Compile("params.json")
+}
+
+// ParamEnv returns the env variable name of a param
+func ParamEnv(name string) string {
+	return "PAL_PARAM_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Params validates a JSON object input against the compiled schema of params, filling in defaults, and returns
+// the values by param name. Strings are unquoted and any other value is JSON
+func Params(params []data.Param, schema *jsonschema.Schema, input string) (map[string]string, error) {
+	if len(params) == 0 {
+		return nil, nil
+	}
+
+	values := map[string]any{}
+	if strings.TrimSpace(input) != "" {
+		doc, err := jsonschema.UnmarshalJSON(strings.NewReader(input))
+		if err != nil {
+			return nil, errors.New("input is not a json object")
+		}
+		m, ok := doc.(map[string]any)
+		if !ok {
+			return nil, errors.New("input is not a json object")
+		}
+		values = m
+	}
+
+	for _, p := range params {
+		if _, ok := values[p.Name]; !ok && p.Default != "" {
+			values[p.Name], _ = ParamValue(p.Type, p.Default)
+		}
+	}
+
+	if err := schema.Validate(values); err != nil {
+		var ve *jsonschema.ValidationError
+		if errors.As(err, &ve) {
+			return nil, errors.New(strings.Join(validationErrors(ve), ", "))
+		}
+		return nil, err
+	}
+
+	out := make(map[string]string, len(params))
+	for _, p := range params {
+		v, ok := values[p.Name]
+		if !ok {
+			continue
+		}
+		if s, ok := v.(string); ok {
+			out[p.Name] = s
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		out[p.Name] = string(b)
+	}
+
+	return out, nil
+}
+
+func validationErrors(ve *jsonschema.ValidationError) []string {
+	if len(ve.Causes) == 0 {
+		return []string{ve.Error()}
+	}
+
+	var errs []string
+	for _, cause := range ve.Causes {
+		errs = append(errs, validationErrors(cause)...)
+	}
+
+	return errs
+}
+
+// RetryDelay returns the pause before the next retry. retry_interval is multiplied by retry_backoff for every
+// attempt and capped at retry_max_interval, retry_jitter picks a random delay between half and all of it
+func RetryDelay(onError data.OnError, attempt int) time.Duration {
+	interval := float64(onError.RetryInterval)
+	if onError.RetryBackoff > 1 {
+		interval *= math.Pow(onError.RetryBackoff, float64(attempt))
+	}
+	if onError.RetryMaxInterval > 0 && interval > float64(onError.RetryMaxInterval) {
+		interval = float64(onError.RetryMaxInterval)
+	}
+
+	delay := time.Duration(interval * float64(time.Second))
+	if onError.RetryJitter && delay > 0 {
+		delay = delay/2 + mathrand.N(delay/2+1) // #nosec G404
+	}
+
+	return delay
+}
+
+// ReadEnvFile reads KEY=VALUE lines of an env_file, blank lines, # comments and an export prefix are skipped
+// and values can be wrapped in single or double quotes
+func ReadEnvFile(location string) ([]string, error) {
+	file, err := os.ReadFile(filepath.Clean(location))
+	if err != nil {
+		return nil, fmt.Errorf("error reading env_file %s: %w", location, err)
+	}
+
+	env := []string{}
+	for i, line := range strings.Split(string(file), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("error env_file %s line %d is not KEY=VALUE", location, i+1)
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) > 1 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		env = append(env, key+"="+value)
+	}
+
+	return env, nil
+}
+
+// EnvList returns an env map as KEY=VALUE sorted by key
+func EnvList(env map[string]string) []string {
+	list := make([]string, 0, len(env))
+	for _, key := range slices.Sorted(maps.Keys(env)) {
+		list = append(list, key+"="+env[key])
+	}
+
+	return list
+}
+
+// ExitCode returns the exit code of a CmdRun error, 0 on success and -1 if the cmd never exited
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
 
-		// If it's a context timeout or the maximum retries are reached, return the error
-		return errStr, fmtDuration(int(time.Since(startTime).Seconds())), errors.New(errStr)
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
 	}
 
-	return strings.TrimSpace(string(output)), fmtDuration(int(time.Since(startTime).Seconds())), nil
+	return -1
 }
 
 // HasAction verify action is not empty
@@ -217,17 +922,31 @@ func updateAction(oldAction, newAction data.ActionData) data.ActionData {
 	oldAction.Desc = newAction.Desc
 	oldAction.Background = newAction.Background
 	oldAction.Concurrent = newAction.Concurrent
+	oldAction.MaxConcurrent = newAction.MaxConcurrent
+	oldAction.Queue = newAction.Queue
 	oldAction.AuthHeader = newAction.AuthHeader
+	oldAction.Auth = newAction.Auth
+	oldAction.Approval = newAction.Approval
+	oldAction.AllowedWindows = newAction.AllowedWindows
+	oldAction.BlockedWindows = newAction.BlockedWindows
 	oldAction.Output = newAction.Output
+	oldAction.OutputFormat = newAction.OutputFormat
 	oldAction.Timeout = newAction.Timeout
 	oldAction.Container = newAction.Container
+	oldAction.CmdPrefix = newAction.CmdPrefix
 	oldAction.Cmd = newAction.Cmd
+	oldAction.Args = newAction.Args
+	oldAction.Env = newAction.Env
+	oldAction.EnvFile = newAction.EnvFile
+	oldAction.SuccessExitCodes = newAction.SuccessExitCodes
 	oldAction.ResponseHeaders = newAction.ResponseHeaders
 	oldAction.Schedule = newAction.Schedule
+	oldAction.Watch = newAction.Watch
 	oldAction.OnError = newAction.OnError
 	oldAction.OnSuccess = newAction.OnSuccess
 	oldAction.Input = newAction.Input
 	oldAction.InputValidate = newAction.InputValidate
+	oldAction.Params = newAction.Params
 	oldAction.Triggers = newAction.Triggers
 	oldAction.Register = newAction.Register
 
@@ -241,7 +960,7 @@ func GetLastOutput(action data.ActionData) string {
 	return action.LastFailureOutput
 }
 
-func fmtDuration(seconds int) string {
+func FmtDuration(seconds int) string {
 	duration := time.Duration(seconds) * time.Second
 	days := int(duration.Hours() / day)
 	hours := int(duration.Hours()) % day